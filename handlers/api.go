@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by operations on a key that does not exist
+	ErrNotFound = errors.New("key not found")
+	// ErrWrongType is returned by operations on a key holding another type
+	// of value than the one they expect
+	ErrWrongType = errors.New(wrongTypeMsg)
+	// ErrDBIndex is returned when selecting a database that does not exist
+	ErrDBIndex = errors.New("DB index is out of range")
+)
+
+// DB is the Go API of a logical database, to use Ledis in-process without
+// going through the HTTP front end. The commands sent over HTTP are adapters
+// on top of it. Values are copied in and out, callers may reuse their slices.
+type DB struct {
+	db *ledisDB
+	// executing is set for the command adapters, which already run on the
+	// executor in serial mode
+	executing bool
+}
+
+// DB returns the database at index, as selected with SELECT
+func (store *LedisStore) DB(index int) (*DB, error) {
+	if index < 0 || index >= len(store.dbs) {
+		return nil, ErrDBIndex
+	}
+	return &DB{db: store.dbs[index]}, nil
+}
+
+func (db *ledisDB) api() *DB {
+	return &DB{db: db, executing: true}
+}
+
+// Get returns the string value at key and whether the key exists
+func (d *DB) Get(key string) ([]byte, bool, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if storeVal.DataType != TypeString {
+		return nil, true, ErrWrongType
+	}
+	return []byte(*storeVal.StringData), true, nil
+}
+
+// Set sets key to a string value, overwriting any value and expiration. It
+// fails with ErrOOM, like the other writes, when the used memory is over
+// maxmemory and no key can be evicted.
+func (d *DB) Set(key string, val []byte) error {
+	if err := d.freeMemory(); err != nil {
+		return err
+	}
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	str := string(val)
+	db.put(key, LedisData{
+		DataType:   TypeString,
+		SetData:    nil,
+		ListData:   nil,
+		StringData: &str})
+	db.persist(key)
+	db.notify(notifyString, "set", key)
+	return nil
+}
+
+// Del deletes keys and returns how many existed
+func (d *DB) Del(keys ...string) int {
+	db := d.db
+	unlock := d.lockKeys(keys...)
+	defer unlock()
+
+	count := 0
+	for _, key := range keys {
+		if _, ok := db.lookup(key); !ok {
+			continue
+		}
+		db.remove(key)
+		db.notify(notifyGeneric, "del", key)
+		count++
+	}
+	return count
+}
+
+// Exists returns how many of keys exist, a key given twice counts twice
+func (d *DB) Exists(keys ...string) int {
+	db := d.db
+	unlock := d.rlockKeys(keys...)
+	defer unlock()
+	db.countReads(keys...)
+
+	return db.countExisting(keys)
+}
+
+// Type returns the type of the value at key: string, list, set, or none
+// when the key does not exist
+func (d *DB) Type(key string) string {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return "none"
+	}
+	return typeName(storeVal.DataType)
+}
+
+// Keys returns the keys matching the glob style pattern
+func (d *DB) Keys(pattern string) []string {
+	db := d.db
+	unlock := d.rlockAll()
+	defer unlock()
+
+	keys := []string{}
+	db.each(func(key string, _ LedisData) {
+		if globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// Expire sets key to expire after ttl, with a one second resolution
+func (d *DB) Expire(key string, ttl time.Duration) error {
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	if _, ok := db.lookup(key); !ok {
+		return ErrNotFound
+	}
+	db.setExpireTime(key, time.Now().Add(ttl).Unix())
+	db.notify(notifyGeneric, "expire", key)
+	return nil
+}
+
+// TTL returns the time to live of key, and false when it does not expire
+func (d *DB) TTL(key string) (time.Duration, bool, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	if _, ok := db.lookup(key); !ok {
+		return 0, false, ErrNotFound
+	}
+	expireTime, ok := db.expireTime(key)
+	if !ok {
+		return 0, false, nil
+	}
+	return time.Duration(expireTime-time.Now().Unix()) * time.Second, true, nil
+}
+
+// LPush prepends vals one after another to the list at key, creating it if
+// needed, and returns the length of the list
+func (d *DB) LPush(key string, vals ...[]byte) (int, error) {
+	return d.push(key, vals, true, false)
+}
+
+// RPush appends vals to the list at key, creating it if needed, and returns
+// the length of the list
+func (d *DB) RPush(key string, vals ...[]byte) (int, error) {
+	return d.push(key, vals, false, false)
+}
+
+// LPushX is LPush for lists that already exist, it returns 0 otherwise
+func (d *DB) LPushX(key string, vals ...[]byte) (int, error) {
+	return d.push(key, vals, true, true)
+}
+
+// RPushX is RPush for lists that already exist, it returns 0 otherwise
+func (d *DB) RPushX(key string, vals ...[]byte) (int, error) {
+	return d.push(key, vals, false, true)
+}
+
+func (d *DB) push(key string, vals [][]byte, left, onlyExisting bool) (int, error) {
+	if err := d.freeMemory(); err != nil {
+		return 0, err
+	}
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	storeVal, ok := db.lookup(key)
+	if ok && storeVal.DataType != TypeList {
+		return 0, ErrWrongType
+	}
+	if !ok && onlyExisting {
+		return 0, nil
+	}
+	return db.pushValues(key, toStrings(vals), left), nil
+}
+
+// LPop removes and returns the first element of the list at key
+func (d *DB) LPop(key string) ([]byte, error) {
+	return d.pop(key, true)
+}
+
+// RPop removes and returns the last element of the list at key
+func (d *DB) RPop(key string) ([]byte, error) {
+	return d.pop(key, false)
+}
+
+func (d *DB) pop(key string, left bool) ([]byte, error) {
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	if _, err := db.list(key); err != nil {
+		return nil, err
+	}
+	val := db.popValue(key, left)
+	db.deleteIfEmpty(key)
+	return []byte(val), nil
+}
+
+// LLen returns the length of the list at key
+func (d *DB) LLen(key string) (int, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	listData, err := db.list(key)
+	if err != nil {
+		return 0, err
+	}
+	return listData.Len(), nil
+}
+
+// LRange returns the elements of the list at key from start to stop
+// included, negative indexes counting from the tail
+func (d *DB) LRange(key string, start, stop int) ([][]byte, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	listData, err := db.list(key)
+	if err != nil {
+		return nil, err
+	}
+	from, to := listRange(start, stop, listData.Len())
+	return toBytes(listData.Slice(from, to)), nil
+}
+
+// LIndex returns the element at index of the list at key, negative indexes
+// counting from the tail, and false when the index is out of range
+func (d *DB) LIndex(key string, index int) ([]byte, bool, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	listData, err := db.list(key)
+	if err != nil {
+		return nil, false, err
+	}
+	offset, ok := listIndex(index, listData.Len())
+	if !ok {
+		return nil, false, nil
+	}
+	return []byte(listData.Index(offset)), true, nil
+}
+
+// SAdd adds members to the set at key, creating it if needed, and returns
+// how many were not members yet
+func (d *DB) SAdd(key string, members ...[]byte) (int, error) {
+	if err := d.freeMemory(); err != nil {
+		return 0, err
+	}
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return 0, ErrWrongType
+	}
+	if set == nil {
+		set = newLedisSet(nil)
+		db.put(key, LedisData{
+			DataType:   TypeSet,
+			SetData:    set,
+			ListData:   nil,
+			StringData: nil})
+	}
+
+	count := 0
+	limits := db.limits()
+	for _, member := range members {
+		if set.Add(string(member), limits) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.resized(key)
+		db.notify(notifySet, "sadd", key)
+	}
+	return count, nil
+}
+
+// SRem removes members from the set at key and returns how many were
+// members
+func (d *DB) SRem(key string, members ...[]byte) (int, error) {
+	db := d.db
+	unlock := d.lockKeys(key)
+	defer unlock()
+
+	set, err := db.set(key)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, member := range members {
+		if set.Remove(string(member)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.resized(key)
+		db.notify(notifySet, "srem", key)
+	}
+	return count, nil
+}
+
+// SMembers returns the members of the set at key, in no particular order
+func (d *DB) SMembers(key string) ([][]byte, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, err := db.set(key)
+	if err != nil {
+		return nil, err
+	}
+	return toBytes(set.Members()), nil
+}
+
+// SIsMember reports whether member belongs to the set at key
+func (d *DB) SIsMember(key string, member []byte) (bool, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, err := db.set(key)
+	if err != nil {
+		return false, err
+	}
+	return set.Has(string(member)), nil
+}
+
+// SCard returns the number of members of the set at key
+func (d *DB) SCard(key string) (int, error) {
+	db := d.db
+	unlock := d.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, err := db.set(key)
+	if err != nil {
+		return 0, err
+	}
+	return set.Len(), nil
+}
+
+// lockKeys write locks the shards of keys. In serial mode, a call from
+// outside the executor runs instead of it, see enter.
+func (d *DB) lockKeys(keys ...string) func() {
+	if d.db.serial && !d.executing {
+		return d.db.enter()
+	}
+	return d.db.lockKeys(keys...)
+}
+
+// rlockKeys read locks the shards of keys, like lockKeys
+func (d *DB) rlockKeys(keys ...string) func() {
+	if d.db.serial && !d.executing {
+		return d.db.enter()
+	}
+	return d.db.rlockKeys(keys...)
+}
+
+// rlockAll read locks all the shards, like lockKeys
+func (d *DB) rlockAll() func() {
+	if d.db.serial && !d.executing {
+		return d.db.enter()
+	}
+	return rlockAll(d.db)
+}
+
+// freeMemory evicts keys before a write, failing with ErrOOM when the used
+// memory can't get within maxmemory. Command adapters already run on the
+// executor.
+func (d *DB) freeMemory() error {
+	if d.executing {
+		return d.db.freeMemory()
+	}
+	var err error
+	d.db.execute(func() {
+		err = d.db.freeMemory()
+	})
+	return err
+}
+
+// list returns the list at key, or ErrNotFound or ErrWrongType. The shard
+// lock of key must be held.
+func (db *ledisDB) list(key string) (*ledisList, error) {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if storeVal.DataType != TypeList {
+		return nil, ErrWrongType
+	}
+	return storeVal.ListData, nil
+}
+
+// set returns the set at key, or ErrNotFound or ErrWrongType. The shard
+// lock of key must be held.
+func (db *ledisDB) set(key string) (*ledisSet, error) {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if storeVal.DataType != TypeSet {
+		return nil, ErrWrongType
+	}
+	return storeVal.SetData, nil
+}
+
+// apiErrorReply converts an error of the Go API to a reply, missing keys
+// rendered as text
+func apiErrorReply(err error, notFound reply) reply {
+	switch err {
+	case ErrNotFound:
+		return notFound
+	case ErrWrongType:
+		return wrongTypeReply()
+	case ErrOOM:
+		return oomReply()
+	default:
+		return errorReply(err)
+	}
+}
+
+func toStrings(vals [][]byte) []string {
+	strs := make([]string, 0, len(vals))
+	for _, val := range vals {
+		strs = append(strs, string(val))
+	}
+	return strs
+}
+
+func toBytes(strs []string) [][]byte {
+	vals := make([][]byte, 0, len(strs))
+	for _, str := range strs {
+		vals = append(vals, []byte(str))
+	}
+	return vals
+}
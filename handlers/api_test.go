@@ -0,0 +1,109 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestGoAPI(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithDatabases(2))
+	defer store.Close()
+	g := NewGomegaWithT(t)
+
+	db, err := store.DB(0)
+	g.Expect(err).To(BeNil())
+	_, err = store.DB(2)
+	g.Expect(err).To(Equal(handlers.ErrDBIndex))
+
+	// strings
+	val, ok, err := db.Get("key")
+	g.Expect(val).To(BeNil())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(err).To(BeNil())
+	buf := []byte("value")
+	g.Expect(db.Set("key", buf)).To(Succeed())
+	buf[0] = 'V'
+	val, ok, err = db.Get("key")
+	g.Expect(string(val)).To(Equal("value"), "Values are copied in")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(err).To(BeNil())
+	g.Expect(db.Type("key")).To(Equal("string"))
+
+	// lists
+	length, err := db.RPush("list", []byte("b"), []byte("c"))
+	g.Expect(length).To(Equal(2))
+	g.Expect(err).To(BeNil())
+	length, _ = db.LPush("list", []byte("a"))
+	g.Expect(length).To(Equal(3))
+	vals, err := db.LRange("list", 0, -1)
+	g.Expect(err).To(BeNil())
+	g.Expect(vals).To(Equal([][]byte{[]byte("a"), []byte("b"), []byte("c")}))
+	elem, ok, _ := db.LIndex("list", -1)
+	g.Expect(string(elem)).To(Equal("c"))
+	g.Expect(ok).To(BeTrue())
+	_, ok, _ = db.LIndex("list", 3)
+	g.Expect(ok).To(BeFalse())
+	elem, err = db.LPop("list")
+	g.Expect(string(elem)).To(Equal("a"))
+	length, _ = db.LLen("list")
+	g.Expect(length).To(Equal(2))
+	length, err = db.RPushX("no-exist", []byte("a"))
+	g.Expect(length).To(Equal(0))
+	g.Expect(err).To(BeNil())
+	_, err = db.RPop("no-exist")
+	g.Expect(err).To(Equal(handlers.ErrNotFound))
+	_, err = db.LPush("key", []byte("a"))
+	g.Expect(err).To(Equal(handlers.ErrWrongType))
+	_, err = db.LLen("key")
+	g.Expect(err).To(Equal(handlers.ErrWrongType))
+
+	// sets
+	count, err := db.SAdd("set", []byte("a"), []byte("b"), []byte("a"))
+	g.Expect(count).To(Equal(2), "Duplicates are counted once")
+	g.Expect(err).To(BeNil())
+	isMember, _ := db.SIsMember("set", []byte("b"))
+	g.Expect(isMember).To(BeTrue())
+	count, _ = db.SRem("set", []byte("b"), []byte("x"))
+	g.Expect(count).To(Equal(1))
+	members, _ := db.SMembers("set")
+	g.Expect(members).To(Equal([][]byte{[]byte("a")}))
+	count, _ = db.SCard("set")
+	g.Expect(count).To(Equal(1))
+	_, err = db.SCard("no-exist")
+	g.Expect(err).To(Equal(handlers.ErrNotFound))
+	_, err = db.SAdd("list", []byte("a"))
+	g.Expect(err).To(Equal(handlers.ErrWrongType))
+
+	// keys
+	g.Expect(db.Exists("key", "list", "no-exist")).To(Equal(2))
+	g.Expect(db.Keys("*")).To(ConsistOf("key", "list", "set"))
+	g.Expect(db.Expire("no-exist", time.Minute)).To(Equal(handlers.ErrNotFound))
+	_, hasTTL, err := db.TTL("key")
+	g.Expect(hasTTL).To(BeFalse())
+	g.Expect(err).To(BeNil())
+	g.Expect(db.Expire("key", time.Minute)).To(Succeed())
+	ttl, hasTTL, _ := db.TTL("key")
+	g.Expect(hasTTL).To(BeTrue())
+	g.Expect(ttl).To(BeNumerically("~", time.Minute, time.Second))
+	_, _, err = db.TTL("no-exist")
+	g.Expect(err).To(Equal(handlers.ErrNotFound))
+	g.Expect(db.Del("key", "no-exist")).To(Equal(1))
+	g.Expect(db.Type("key")).To(Equal("none"))
+
+	// the HTTP front end sees the same data
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g.Expect(SendCommand(`LRANGE list 0 -1`)).To(Equal("b\r\nc\r\n"))
+	g.Expect(SendCommand(`SET key 1`)).To(Equal("OK"))
+	val, _, _ = db.Get("key")
+	g.Expect(string(val)).To(Equal("1"))
+
+	other, _ := store.DB(1)
+	g.Expect(other.Exists("key")).To(Equal(0), "Databases are separate")
+}
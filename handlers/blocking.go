@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// listWaiter is a client blocked until one of its keys holds a non empty
+// list. Waiters are served in the order they blocked.
+type listWaiter struct {
+	keys    []string
+	popLeft bool
+
+	// BLMOVE destination, empty for BLPOP and BRPOP
+	dest     string
+	pushLeft bool
+
+	db      *ledisDB
+	timeout time.Duration
+
+	// served is set, with blockLock held, once the waiter is removed from
+	// the queues, right before it is handed its reply
+	served bool
+	result chan reply
+}
+
+func blockingCommand(db *ledisDB, c *client, name string, cmd *command) reply {
+	if name == "BLMOVE" {
+		if len(cmd.Args) != 5 {
+			return errorReply(fmt.Errorf("BLMOVE expects 5 arguments"))
+		}
+		popLeft, err := parseListSide(cmd.Args[2])
+		if err != nil {
+			return errorReply(err)
+		}
+		pushLeft, err := parseListSide(cmd.Args[3])
+		if err != nil {
+			return errorReply(err)
+		}
+		timeout, err := parseTimeout(cmd.Args[4])
+		if err != nil {
+			return errorReply(err)
+		}
+		rep, waiter := db.Blmove(cmd.Args[0], cmd.Args[1], popLeft, pushLeft, timeout)
+		c.blocked = waiter
+		return rep
+	}
+
+	if len(cmd.Args) < 2 {
+		return errorReply(fmt.Errorf("%s expects at least 2 arguments", name))
+	}
+	timeout, err := parseTimeout(cmd.Args[len(cmd.Args)-1])
+	if err != nil {
+		return errorReply(err)
+	}
+	rep, waiter := db.Bpop(cmd.Args[:len(cmd.Args)-1], name == "BLPOP", timeout)
+	c.blocked = waiter
+	return rep
+}
+
+func parseListSide(side string) (bool, error) {
+	switch strings.ToUpper(side) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	default:
+		return false, fmt.Errorf("Error when parsing side, expects LEFT or RIGHT")
+	}
+}
+
+// parseTimeout parses a blocking timeout in seconds, 0 means no timeout
+func parseTimeout(arg string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("Error when parsing timeout")
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("Timeout should not be negative")
+	}
+	if seconds > float64(math.MaxInt64)/float64(time.Second) {
+		return 0, fmt.Errorf("timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Bpop pops an element from the head (left) or the tail of the first non
+// empty list among keys. When they are all empty, it returns the waiter to
+// wait on with waitBlocked, until an element is pushed to one of them or the
+// timeout elapses. Waiting is left to the caller, so that it does not hold
+// up the executor in serial mode.
+func (db *ledisDB) Bpop(keys []string, left bool, timeout time.Duration) (reply, *listWaiter) {
+	unlock := db.lockKeys(keys...)
+	defer unlock()
+	for _, key := range keys {
+		storeVal, ok := db.lookup(key)
+		if !ok {
+			continue
+		}
+		if storeVal.DataType != TypeList {
+			return wrongTypeReply(), nil
+		}
+		if storeVal.ListData.Len() > 0 {
+			val := db.popValue(key, left)
+			db.deleteIfEmpty(key)
+			return bulkArrayReply([]string{key, val}), nil
+		}
+	}
+	return reply{}, db.block(&listWaiter{keys: keys, popLeft: left, timeout: timeout})
+}
+
+// Blmove atomically moves an element from the head (popLeft) or the tail of
+// the list at src to the head (pushLeft) or the tail of the list at dest,
+// blocking like Bpop while src is empty.
+func (db *ledisDB) Blmove(src, dest string, popLeft, pushLeft bool, timeout time.Duration) (reply, *listWaiter) {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep, nil
+	}
+	unlock := db.lockKeys(src, dest)
+	defer unlock()
+	srcVal, ok := db.lookup(src)
+	if ok && srcVal.DataType != TypeList {
+		return wrongTypeReply(), nil
+	}
+	if ok && srcVal.ListData.Len() > 0 {
+		return db.moveValue(src, dest, popLeft, pushLeft), nil
+	}
+	return reply{}, db.block(&listWaiter{keys: []string{src}, popLeft: popLeft, dest: dest, pushLeft: pushLeft, timeout: timeout})
+}
+
+// moveValue moves an element between lists, src must hold a non empty list.
+// The shard locks of src and dest must be held.
+func (db *ledisDB) moveValue(src, dest string, popLeft, pushLeft bool) reply {
+	if destVal, ok := db.lookup(dest); ok && destVal.DataType != TypeList {
+		return wrongTypeReply()
+	}
+
+	// the source is deleted only after the push, so that rotating a single
+	// element list keeps the key
+	val := db.popValue(src, popLeft)
+	db.pushValues(dest, []string{val}, pushLeft)
+	db.deleteIfEmpty(src)
+	return bulkReply(val)
+}
+
+// block queues waiter on all its keys. The shard locks of the keys must be
+// held, so that no push slips between checking the lists and blocking.
+func (db *ledisDB) block(waiter *listWaiter) *listWaiter {
+	db.lockBlocked()
+	defer db.unlockBlocked()
+
+	waiter.db = db
+	waiter.result = make(chan reply, 1)
+	for _, key := range waiter.keys {
+		db.blocked[key] = append(db.blocked[key], waiter)
+	}
+	atomic.AddInt32(&db.waiting, 1)
+	return waiter
+}
+
+// unblock removes waiter from the queues of all its keys. blockLock must be
+// held.
+func (db *ledisDB) unblock(waiter *listWaiter) {
+	waiter.served = true
+	for _, key := range waiter.keys {
+		waiters := db.blocked[key]
+		for i, w := range waiters {
+			if w == waiter {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(db.blocked, key)
+			delete(db.ready, key)
+		} else {
+			db.blocked[key] = waiters
+		}
+	}
+	atomic.AddInt32(&db.waiting, -1)
+}
+
+// signalReady marks key as pushed to, its blocked clients are served once
+// the command releases its locks. The shard lock of key must be held.
+func (db *ledisDB) signalReady(key string) {
+	if atomic.LoadInt32(&db.waiting) == 0 {
+		return
+	}
+	db.lockBlocked()
+	defer db.unlockBlocked()
+	if len(db.blocked[key]) > 0 {
+		db.ready[key] = true
+	}
+}
+
+// signalAllReady marks all the keys clients are blocked on as ready, after
+// a change of a whole database
+func (db *ledisDB) signalAllReady() {
+	db.lockBlocked()
+	defer db.unlockBlocked()
+	for key := range db.blocked {
+		db.ready[key] = true
+	}
+}
+
+// serveReady serves the clients blocked on the ready keys. It is called by
+// the commands pushing to lists once they release their locks, so that
+// serving a BLMOVE can lock the shard of its destination. No lock must be
+// held.
+func (db *ledisDB) serveReady() {
+	if atomic.LoadInt32(&db.waiting) == 0 {
+		return
+	}
+	for {
+		db.lockBlocked()
+		key, found := "", false
+		for key = range db.ready {
+			found = true
+			delete(db.ready, key)
+			break
+		}
+		db.unlockBlocked()
+		if !found {
+			return
+		}
+		for db.serveFirst(key) {
+		}
+	}
+}
+
+// serveFirst hands an element of the list at key out to the first client
+// blocked on it, first blocked first served. It reports whether there may
+// be more clients to serve.
+func (db *ledisDB) serveFirst(key string) bool {
+	db.lockBlocked()
+	if len(db.blocked[key]) == 0 {
+		db.unlockBlocked()
+		return false
+	}
+	waiter := db.blocked[key][0]
+	db.unlockBlocked()
+
+	// blockLock comes after the shard locks, the waiter is checked again
+	// once they are held
+	keys := []string{key}
+	if waiter.dest != "" {
+		keys = append(keys, waiter.dest)
+	}
+	release := acquire(true, db.shardsOf(keys...))
+	defer release()
+
+	db.lockBlocked()
+	if waiter.served {
+		// timed out meanwhile, on to the next one
+		db.unlockBlocked()
+		return true
+	}
+	storeVal, ok := db.lookup(key)
+	if !ok || storeVal.DataType != TypeList || storeVal.ListData.Len() == 0 {
+		db.unlockBlocked()
+		return false
+	}
+	db.unblock(waiter)
+	db.unlockBlocked()
+
+	if waiter.dest != "" {
+		waiter.result <- db.moveValue(key, waiter.dest, waiter.popLeft, waiter.pushLeft)
+	} else {
+		waiter.result <- bulkArrayReply([]string{key, db.popValue(key, waiter.popLeft)})
+		db.deleteIfEmpty(key)
+	}
+	return true
+}
+
+// waitBlocked waits for waiter to be served, returning a nil reply when its
+// timeout (if not 0) elapses or ctx is done first.
+func (db *ledisDB) waitBlocked(ctx context.Context, waiter *listWaiter) reply {
+	var expired <-chan time.Time
+	if waiter.timeout > 0 {
+		timer := time.NewTimer(waiter.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case rep := <-waiter.result:
+		return rep
+	case <-expired:
+	case <-ctx.Done():
+	case <-db.ctx.Done():
+	}
+
+	served := false
+	db.execute(func() {
+		db.lockBlocked()
+		defer db.unlockBlocked()
+		served = waiter.served
+		if !served {
+			db.unblock(waiter)
+		}
+	})
+	if served {
+		// served while timing out, the reply is on its way
+		return <-waiter.result
+	}
+	return nilReply("")
+}
+
+// lockBlocked takes blockLock, in mutex mode only
+func (db *ledisDB) lockBlocked() {
+	if !db.serial {
+		db.blockLock.Lock()
+	}
+}
+
+func (db *ledisDB) unlockBlocked() {
+	if !db.serial {
+		db.blockLock.Unlock()
+	}
+}
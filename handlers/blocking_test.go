@@ -0,0 +1,100 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// SendCommandAsync sends cmd in the background, the reply is sent on the
+// returned channel
+func SendCommandAsync(cmd string) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		result <- SendCommand(cmd)
+	}()
+	return result
+}
+
+func TestBlockingPop(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`RPUSH queue a b`, "2", ""},
+		{`BLPOP empty queue 1`, "queue\r\na\r\n", "Pop without blocking"},
+		{`BRPOP queue 1`, "queue\r\nb\r\n", ""},
+		{`SET testkey 123`, "OK", ""},
+		{`BLPOP empty testkey 1`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`BLMOVE testkey queue LEFT RIGHT 1`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`RPUSH src x`, "1", ""},
+		{`BLMOVE src testkey LEFT RIGHT 1`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`BLMOVE src dest LEFT left 1`, "x", "Move without blocking"},
+		{`LRANGE dest 0 10`, "x\r\n", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	start := time.Now()
+	g.Expect(SendCommand(`BLPOP queue 0.3`)).To(Equal("(nil)"), "Test timeout")
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+
+	// waiters are served in the order they blocked
+	first := SendCommandAsync(`BLPOP queue other 0`)
+	time.Sleep(100 * time.Millisecond)
+	second := SendCommandAsync(`BRPOP other queue 0`)
+	time.Sleep(100 * time.Millisecond)
+	g.Consistently(first, 100*time.Millisecond).ShouldNot(Receive())
+	g.Expect(SendCommand(`RPUSH queue 1 2 3`)).To(Equal("3"))
+	g.Eventually(first).Should(Receive(Equal("queue\r\n1\r\n")))
+	g.Eventually(second).Should(Receive(Equal("queue\r\n3\r\n")))
+	g.Expect(SendCommand(`LRANGE queue 0 10`)).To(Equal("2\r\n"))
+
+	third := SendCommandAsync(`BRPOP empty other 5`)
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(SendCommand(`RPUSH other y`)).To(Equal("1"))
+	g.Eventually(third).Should(Receive(Equal("other\r\ny\r\n")))
+
+	// a blocked BLMOVE pushes to its destination, waking its waiters
+	move := SendCommandAsync(`BLMOVE jobs processing RIGHT LEFT 0`)
+	time.Sleep(100 * time.Millisecond)
+	worker := SendCommandAsync(`BLPOP processing 0`)
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(SendCommand(`RPUSH jobs job1`)).To(Equal("1"))
+	g.Eventually(move).Should(Receive(Equal("job1")))
+	g.Eventually(worker).Should(Receive(Equal("processing\r\njob1\r\n")))
+	g.Expect(SendCommand(`LLEN jobs`)).To(Equal("key not found"))
+	g.Expect(SendCommand(`LLEN processing`)).To(Equal("key not found"))
+
+	// a client going away stops waiting
+	_, _, errs := gorequest.New().Timeout(200 * time.Millisecond).Post(serverUrl).Type("text").SendString(`BLPOP gone 0`).End()
+	g.Expect(errs).NotTo(BeEmpty())
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(SendCommand(`RPUSH gone z`)).To(Equal("1"))
+	g.Expect(SendCommand(`LLEN gone`)).To(Equal("1"))
+
+	invalid := []ValidateExactTest{
+		{`BLPOP queue`, "ERROR: BLPOP expects at least 2 arguments", ""},
+		{`BRPOP queue abc`, "ERROR: Error when parsing timeout", ""},
+		{`BLPOP queue -1`, "ERROR: Timeout should not be negative", ""},
+		{`BLPOP nothing 1e12`, "ERROR: timeout is out of range", "Test a timeout overflowing"},
+		{`BLMOVE nothing dest LEFT RIGHT 1e12`, "ERROR: timeout is out of range", ""},
+		{`BLMOVE a b LEFT`, "ERROR: BLMOVE expects 5 arguments", ""},
+		{`BLMOVE a b UP LEFT 0`, "ERROR: Error when parsing side, expects LEFT or RIGHT", ""},
+		{`BLMOVE a b LEFT DOWN 0`, "ERROR: Error when parsing side, expects LEFT or RIGHT", ""},
+	}
+	for _, test := range invalid {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
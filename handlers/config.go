@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// configParam is a server setting readable and writable with CONFIG GET and
+// CONFIG SET, read only when set is nil. Settings are read and written
+// atomically, so that commands read them without locking.
+type configParam struct {
+	get func(store *LedisStore) string
+	set func(store *LedisStore, value string) error
+}
+
+var configParams = map[string]configParam{
+	"databases": {
+		get: func(store *LedisStore) string {
+			return strconv.Itoa(len(store.dbs))
+		},
+	},
+	"notify-keyspace-events": {
+		get: func(store *LedisStore) string {
+			return notifyFlagsString(int(atomic.LoadInt32(&store.notifyFlags)))
+		},
+		set: func(store *LedisStore, value string) error {
+			flags, err := parseNotifyFlags(value)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt32(&store.notifyFlags, int32(flags))
+			return nil
+		},
+	},
+	"maxmemory": {
+		get: func(store *LedisStore) string {
+			return strconv.FormatInt(atomic.LoadInt64(&store.maxMemory), 10)
+		},
+		set: func(store *LedisStore, value string) error {
+			maxMemory, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt64(&store.maxMemory, maxMemory)
+			return nil
+		},
+	},
+	"maxmemory-policy": {
+		get: func(store *LedisStore) string {
+			return evictionPolicies[atomic.LoadInt32(&store.maxMemoryPolicy)]
+		},
+		set: func(store *LedisStore, value string) error {
+			policy, err := parseEvictionPolicy(value)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt32(&store.maxMemoryPolicy, int32(policy))
+			return nil
+		},
+	},
+	"maxmemory-samples": {
+		get: func(store *LedisStore) string {
+			return strconv.Itoa(int(atomic.LoadInt32(&store.maxMemorySamples)))
+		},
+		set: func(store *LedisStore, value string) error {
+			samples, err := strconv.Atoi(value)
+			if err != nil || samples < 1 || samples > 64 {
+				return fmt.Errorf("argument must be between 1 and 64")
+			}
+			atomic.StoreInt32(&store.maxMemorySamples, int32(samples))
+			return nil
+		},
+	},
+	"list-max-listpack-size": {
+		get: func(store *LedisStore) string {
+			return strconv.Itoa(int(atomic.LoadInt32(&store.listMaxListpackSize)))
+		},
+		set: func(store *LedisStore, value string) error {
+			size, err := strconv.Atoi(value)
+			if err != nil || size < -5 || size == 0 || size > math.MaxInt32 {
+				return fmt.Errorf("argument must be between -5 and -1 or positive")
+			}
+			atomic.StoreInt32(&store.listMaxListpackSize, int32(size))
+			return nil
+		},
+	},
+	"slowlog-log-slower-than": {
+		get: func(store *LedisStore) string {
+			return strconv.FormatInt(atomic.LoadInt64(&store.slowlogSlowerThan), 10)
+		},
+		set: func(store *LedisStore, value string) error {
+			threshold, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			atomic.StoreInt64(&store.slowlogSlowerThan, threshold)
+			return nil
+		},
+	},
+	"slowlog-max-len": {
+		get: func(store *LedisStore) string {
+			return strconv.Itoa(int(atomic.LoadInt32(&store.slowlogMaxLen)))
+		},
+		set: func(store *LedisStore, value string) error {
+			maxLen, err := strconv.Atoi(value)
+			if err != nil || maxLen < 0 || maxLen > math.MaxInt32 {
+				return fmt.Errorf("argument must be a non negative number")
+			}
+			atomic.StoreInt32(&store.slowlogMaxLen, int32(maxLen))
+			return nil
+		},
+	},
+	"set-max-intset-entries":   encodingLimitParam(func(store *LedisStore) *int32 { return &store.setMaxIntsetEntries }),
+	"set-max-listpack-entries": encodingLimitParam(func(store *LedisStore) *int32 { return &store.setMaxListpackEntries }),
+	"set-max-listpack-value":   encodingLimitParam(func(store *LedisStore) *int32 { return &store.setMaxListpackValue }),
+}
+
+// encodingLimitParam is a limit of the compact encodings, a non negative
+// number stored in the field returned by limit
+func encodingLimitParam(limit func(store *LedisStore) *int32) configParam {
+	return configParam{
+		get: func(store *LedisStore) string {
+			return strconv.Itoa(int(atomic.LoadInt32(limit(store))))
+		},
+		set: func(store *LedisStore, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > math.MaxInt32 {
+				return fmt.Errorf("argument must be a non negative number")
+			}
+			atomic.StoreInt32(limit(store), int32(n))
+			return nil
+		},
+	}
+}
+
+func configCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 1 {
+		return errorReply(fmt.Errorf("CONFIG expects at least 1 argument"))
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "GET":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("CONFIG GET expects 1 argument"))
+		}
+		return db.ConfigGet(cmd.Args[1])
+	case "SET":
+		if len(cmd.Args) != 3 {
+			return errorReply(fmt.Errorf("CONFIG SET expects 2 arguments"))
+		}
+		return db.ConfigSet(cmd.Args[1], cmd.Args[2])
+	default:
+		return errorReply(fmt.Errorf("unknown CONFIG subcommand: %s", cmd.Args[0]))
+	}
+}
+
+// ConfigGet returns the names and values of the parameters matching pattern
+func (db *ledisDB) ConfigGet(pattern string) reply {
+	names := []string{}
+	for name := range configParams {
+		if globMatch(strings.ToLower(pattern), name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	vals := []string{}
+	for _, name := range names {
+		vals = append(vals, name, configParams[name].get(db.LedisStore))
+	}
+	return bulkArrayReply(vals)
+}
+
+func (db *ledisDB) ConfigSet(name, value string) reply {
+	if err := db.LedisStore.ConfigSet(name, value); err != nil {
+		return errorReply(err)
+	}
+	return statusReply("OK")
+}
+
+// ConfigSet changes a setting like CONFIG SET does, for instance to set
+// maxmemory at startup
+func (store *LedisStore) ConfigSet(name, value string) error {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unsupported CONFIG parameter: %s", name)
+	}
+	if param.set == nil {
+		return fmt.Errorf("CONFIG parameter %s can't be set at runtime", name)
+	}
+	if err := param.set(store, value); err != nil {
+		return fmt.Errorf("invalid argument '%s' for CONFIG SET '%s': %s", value, name, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	// dbHeader selects the database of a request, overriding dbCookie
+	dbHeader = "X-Ledis-DB"
+	// dbCookie keeps the database selected with SELECT across requests
+	dbCookie = "ledis-db"
+)
+
+// requestDB returns the database a request starts with, from dbHeader or
+// dbCookie, 0 by default
+func (store *LedisStore) requestDB(r *http.Request) (int, error) {
+	value := r.Header.Get(dbHeader)
+	if value == "" {
+		if cookie, err := r.Cookie(dbCookie); err == nil {
+			value = cookie.Value
+		}
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return store.parseDB(value)
+}
+
+func (store *LedisStore) parseDB(value string) (int, error) {
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 || index >= len(store.dbs) {
+		return 0, fmt.Errorf("DB index is out of range")
+	}
+	return index, nil
+}
+
+func dbCommand(db *ledisDB, c *client, name string, cmd *command) reply {
+	switch name {
+	case "SELECT":
+		if len(cmd.Args) != 1 {
+			return errorReply(fmt.Errorf("SELECT expects 1 argument"))
+		}
+		index, err := db.parseDB(cmd.Args[0])
+		if err != nil {
+			return errorReply(err)
+		}
+		c.db = index
+		return statusReply("OK")
+	case "MOVE":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("MOVE expects 2 arguments"))
+		}
+		index, err := db.parseDB(cmd.Args[1])
+		if err != nil {
+			return errorReply(err)
+		}
+		return db.Move(cmd.Args[0], index)
+	case "SWAPDB":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("SWAPDB expects 2 arguments"))
+		}
+		first, err := db.parseDB(cmd.Args[0])
+		if err != nil {
+			return errorReply(err)
+		}
+		second, err := db.parseDB(cmd.Args[1])
+		if err != nil {
+			return errorReply(err)
+		}
+		return db.Swapdb(first, second)
+	default:
+		if len(cmd.Args) != 0 {
+			return errorReply(fmt.Errorf("FLUSHALL expects no argument"))
+		}
+		return db.Flushall()
+	}
+}
+
+// Move moves key, with its expiration, to the database at index. It returns
+// 0 when the key does not exist or already exists in the destination.
+func (db *ledisDB) Move(key string, index int) reply {
+	if index == db.index {
+		return errorReply(fmt.Errorf("source and destination objects are the same"))
+	}
+	dest := db.dbs[index]
+	unlock := lockShards([]*shard{db.shardOf(key), dest.shardOf(key)})
+	defer unlock()
+
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return intReply(0).withText("key not found")
+	}
+	if _, exists := dest.lookup(key); exists {
+		return intReply(0)
+	}
+
+	expireTime, expires := db.expireTime(key)
+	dest.replaceKey(key, storeVal, expireTime, expires)
+	db.remove(key)
+	db.notify(notifyGeneric, "move_from", key)
+	dest.notify(notifyGeneric, "move_to", key)
+	dest.signalReady(key)
+	return intReply(1)
+}
+
+// Swapdb swaps the contents of two databases, clients see the other
+// database's keys right away
+func (db *ledisDB) Swapdb(first, second int) reply {
+	a, b := db.dbs[first], db.dbs[second]
+	unlock := lockAll(a, b)
+	defer unlock()
+
+	// the databases have as many shards and keys hash the same, so swapping
+	// shard by shard keeps every key in its shard
+	for i := range a.shards {
+		sa, sb := a.shards[i], b.shards[i]
+		sa.Data, sb.Data = sb.Data, sa.Data
+		sa.ExpireTime, sb.ExpireTime = sb.ExpireTime, sa.ExpireTime
+		sa.index, sb.index = sb.index, sa.index
+		sa.used, sb.used = sb.used, sa.used
+		for dataType := range sa.keys {
+			swapCounts(&sa.keys[dataType], &sb.keys[dataType])
+		}
+		swapCounts(&sa.expiring, &sb.expiring)
+	}
+	atomic.AddInt64(&db.stats.dirty, 1)
+
+	// clients blocked on a database may now find their lists
+	a.signalAllReady()
+	b.signalAllReady()
+	return statusReply("OK")
+}
+
+// Flushall deletes the keys of all the databases
+func (db *ledisDB) Flushall() reply {
+	unlock := lockAll(db.dbs...)
+	defer unlock()
+
+	for _, flushed := range db.dbs {
+		flushed.flush()
+	}
+	return statusReply("OK")
+}
+
+// swapCounts swaps two counters read atomically, whose shards are write
+// locked
+func swapCounts(a, b *int64) {
+	atomic.StoreInt64(a, atomic.SwapInt64(b, atomic.LoadInt64(a)))
+}
@@ -0,0 +1,167 @@
+package handlers_test
+
+import (
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// SendDBCommand sends cmd to the database at index
+func SendDBCommand(index, cmd string) string {
+	_, body, errs := gorequest.New().Post(serverUrl).Type("text").
+		Set("X-Ledis-DB", index).SendString(cmd).End()
+	if errs != nil {
+		panic(errs)
+	}
+	return body
+}
+
+func TestDatabases(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET key db0`, "OK", ""},
+		{`CONFIG GET databases`, "databases\r\n16\r\n", ""},
+		{`CONFIG SET databases 4`, "ERROR: CONFIG parameter databases can't be set at runtime", ""},
+		{`SELECT 16`, "ERROR: DB index is out of range", ""},
+		{`SELECT -1`, "ERROR: DB index is out of range", ""},
+		{`SELECT a`, "ERROR: DB index is out of range", ""},
+		{`SELECT 1`, "OK", "Test SELECT"},
+		{`GET key`, "db0", "SELECT only lasts for the request without cookies"},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+
+	// SELECT applies to the rest of a pipeline
+	g.Expect(SendCommand("SET key a\nSELECT 1\nSET key b\nGET key\nSELECT 0\nGET key")).To(
+		Equal(`["OK","OK","OK","b","OK","a"]` + "\n"))
+
+	dbTests := []struct {
+		db      string
+		command string
+		expect  string
+	}{
+		{"1", `GET key`, "b"},
+		{"2", `GET key`, "key not found"},
+		{"16", `GET key`, "ERROR: DB index is out of range"},
+		{"1", `DBSIZE`, "1"},
+
+		// FLUSHDB is scoped to the database, FLUSHALL is not
+		{"2", `SET other 1`, "OK"},
+		{"2", `FLUSHDB`, "OK"},
+		{"2", `DBSIZE`, "0"},
+		{"1", `GET key`, "b"},
+
+		// MOVE carries the TTL
+		{"0", `EXPIRE key 100`, "100"},
+		{"0", `MOVE key 1`, "0"},
+		{"0", `MOVE key 3`, "1"},
+		{"0", `GET key`, "key not found"},
+		{"3", `GET key`, "a"},
+		{"3", `TTL key`, "100"},
+		{"3", `MOVE key 3`, "ERROR: source and destination objects are the same"},
+		{"3", `MOVE no-exist 0`, "key not found"},
+		{"3", `MOVE key 16`, "ERROR: DB index is out of range"},
+
+		// SWAPDB
+		{"0", `SWAPDB 1 3`, "OK"},
+		{"1", `GET key`, "a"},
+		{"3", `GET key`, "b"},
+		{"1", `TTL key`, "100"},
+		{"0", `SWAPDB 1 16`, "ERROR: DB index is out of range"},
+
+		// COPY to another database
+		{"1", `COPY key key DB 2`, "1"},
+		{"2", `GET key`, "a"},
+		{"2", `TTL key`, "100"},
+		{"1", `COPY key key DB 3`, "0"},
+		{"1", `COPY key key DB 3 REPLACE`, "1"},
+		{"3", `GET key`, "a"},
+		{"1", `COPY key key`, "ERROR: source and destination objects are the same"},
+		{"1", `COPY key key DB 1`, "ERROR: source and destination objects are the same"},
+
+		{"4", `RPUSH list x`, "1"},
+		{"4", `SAVE`, "OK"},
+		{"0", `FLUSHALL`, "OK"},
+		{"1", `DBSIZE`, "0"},
+		{"4", `DBSIZE`, "0"},
+		{"0", `RESTORE`, "OK"},
+		{"4", `LRANGE list 0 -1`, "x\r\n"},
+		{"1", `GET key`, "a"},
+	}
+	for _, test := range dbTests {
+		g.Expect(SendDBCommand(test.db, test.command)).To(Equal(test.expect), test.db+": "+test.command)
+	}
+
+	// clients blocked on a database are served by MOVE and SWAPDB
+	blocked := make(chan string, 1)
+	go func() {
+		blocked <- SendDBCommand("5", `BLPOP jobs 0`)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(SendDBCommand("6", `RPUSH jobs j1`)).To(Equal("1"))
+	g.Consistently(blocked, 100*time.Millisecond).ShouldNot(Receive())
+	g.Expect(SendDBCommand("6", `MOVE jobs 5`)).To(Equal("1"))
+	g.Eventually(blocked).Should(Receive(Equal("jobs\r\nj1\r\n")))
+
+	go func() {
+		blocked <- SendDBCommand("5", `BLPOP jobs 0`)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(SendDBCommand("6", `RPUSH jobs j2`)).To(Equal("1"))
+	g.Expect(SendDBCommand("6", `SWAPDB 5 6`)).To(Equal("OK"))
+	g.Eventually(blocked).Should(Receive(Equal("jobs\r\nj2\r\n")))
+}
+
+func TestSelectSession(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithDatabases(2))
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	// a client keeping cookies stays on the selected database
+	jar, _ := cookiejar.New(nil)
+	session := gorequest.New()
+	session.Client.Jar = jar
+	send := func(cmd string) string {
+		_, body, errs := session.Post(serverUrl).Type("text").SendString(cmd).End()
+		g.Expect(errs).To(BeNil())
+		return body
+	}
+
+	g.Expect(send(`SELECT 1`)).To(Equal("OK"))
+	g.Expect(send(`SET key 1`)).To(Equal("OK"))
+	g.Expect(SendCommand(`GET key`)).To(Equal("key not found"))
+	g.Expect(SendDBCommand("1", `GET key`)).To(Equal("1"))
+	g.Expect(send(`SELECT 2`)).To(Equal("ERROR: DB index is out of range"))
+	g.Expect(send(`GET key`)).To(Equal("1"))
+	g.Expect(send(`SELECT 0`)).To(Equal("OK"))
+	g.Expect(send(`GET key`)).To(Equal("key not found"))
+
+	// a snapshot with more databases than configured is rejected
+	bigStore := handlers.NewLedisStore()
+	defer bigStore.Close()
+	bigServer := httptest.NewServer(handlers.NewLedisHandler(bigStore))
+	defer bigServer.Close()
+	serverUrl = bigServer.URL
+	g.Expect(SendDBCommand("9", `SET key 1`)).To(Equal("OK"))
+	g.Expect(SendCommand(`SAVE`)).To(Equal("OK"))
+	serverUrl = server.URL
+	g.Expect(SendCommand(`RESTORE`)).To(Equal("snapshot has database 9, only 2 databases are configured"))
+}
@@ -0,0 +1,199 @@
+package handlers
+
+const minDequeCapacity = 8
+
+// ledisList is the representation of lists. Small lists are packed in a
+// listpack, see list-max-listpack-size, larger ones are a deque backed by a
+// ring buffer, so that pushes and pops at both ends are O(1) amortized and
+// elements are reached by index in O(1). The buffer grows by doubling and
+// shrinks by half once it is a quarter full.
+type ledisList struct {
+	// compact lists use packed instead of the ring buffer
+	compact bool
+	packed  listpack
+
+	// capacity is 0 or a power of two
+	buf  []string
+	head int
+	size int
+
+	// bytes is the estimated size of the elements of the ring buffer
+	bytes int64
+}
+
+// newLedisList returns a compact list of values, converted by put if it is
+// too large
+func newLedisList(values []string) *ledisList {
+	list := &ledisList{compact: true}
+	for _, val := range values {
+		list.PushBack(val)
+	}
+	return list
+}
+
+func (list *ledisList) Len() int {
+	return list.size
+}
+
+// pos returns the buffer position of the i-th element
+func (list *ledisList) pos(i int) int {
+	return (list.head + i) & (len(list.buf) - 1)
+}
+
+func (list *ledisList) Index(i int) string {
+	if list.compact {
+		val, _ := list.packed.entry(list.packed.find(i))
+		return val
+	}
+	return list.buf[list.pos(i)]
+}
+
+func (list *ledisList) Set(i int, val string) {
+	if list.compact {
+		start := list.packed.find(i)
+		_, end := list.packed.entry(start)
+		list.packed = list.packed.splice(start, end, val)
+		return
+	}
+	list.bytes += elemSize(val) - elemSize(list.buf[list.pos(i)])
+	list.buf[list.pos(i)] = val
+}
+
+func (list *ledisList) PushBack(val string) {
+	if list.compact {
+		list.packed = list.packed.append(val)
+		list.size++
+		return
+	}
+	list.grow()
+	list.buf[list.pos(list.size)] = val
+	list.size++
+	list.bytes += elemSize(val)
+}
+
+func (list *ledisList) PushFront(val string) {
+	if list.compact {
+		list.packed = list.packed.splice(0, 0, val)
+		list.size++
+		return
+	}
+	list.grow()
+	list.head = (list.head - 1) & (len(list.buf) - 1)
+	list.buf[list.head] = val
+	list.size++
+	list.bytes += elemSize(val)
+}
+
+func (list *ledisList) PopFront() string {
+	if list.compact {
+		val, end := list.packed.entry(0)
+		list.packed = append(list.packed[:0], list.packed[end:]...)
+		list.size--
+		return val
+	}
+	val := list.buf[list.head]
+	// do not keep a reference to the popped value
+	list.buf[list.head] = ""
+	list.head = (list.head + 1) & (len(list.buf) - 1)
+	list.size--
+	list.bytes -= elemSize(val)
+	list.shrink()
+	return val
+}
+
+func (list *ledisList) PopBack() string {
+	if list.compact {
+		start := list.packed.find(list.size - 1)
+		val, _ := list.packed.entry(start)
+		list.packed = list.packed[:start]
+		list.size--
+		return val
+	}
+	last := list.pos(list.size - 1)
+	val := list.buf[last]
+	list.buf[last] = ""
+	list.size--
+	list.bytes -= elemSize(val)
+	list.shrink()
+	return val
+}
+
+// Insert inserts val at index i, shifting the following elements
+func (list *ledisList) Insert(i int, val string) {
+	if list.compact {
+		pos := list.packed.find(i)
+		list.packed = list.packed.splice(pos, pos, val)
+		list.size++
+		return
+	}
+	list.PushBack(val)
+	for j := list.size - 1; j > i; j-- {
+		list.buf[list.pos(j)] = list.buf[list.pos(j-1)]
+	}
+	list.buf[list.pos(i)] = val
+}
+
+// Slice returns a copy of the elements in [from, to)
+func (list *ledisList) Slice(from, to int) []string {
+	vals := make([]string, 0, to-from)
+	if list.compact {
+		pos := list.packed.find(from)
+		for i := from; i < to; i++ {
+			var val string
+			val, pos = list.packed.entry(pos)
+			vals = append(vals, val)
+		}
+		return vals
+	}
+	for i := from; i < to; i++ {
+		vals = append(vals, list.Index(i))
+	}
+	return vals
+}
+
+func (list *ledisList) Values() []string {
+	return list.Slice(0, list.size)
+}
+
+// expand converts a compact list to a ring buffer
+func (list *ledisList) expand() {
+	vals := list.Values()
+	*list = ledisList{}
+	for _, val := range vals {
+		list.PushBack(val)
+	}
+}
+
+// memory returns the estimated bytes used by the elements
+func (list *ledisList) memory() int64 {
+	if list.compact {
+		return int64(len(list.packed))
+	}
+	return list.bytes
+}
+
+func (list *ledisList) grow() {
+	if list.size < len(list.buf) {
+		return
+	}
+	capacity := 2 * len(list.buf)
+	if capacity < minDequeCapacity {
+		capacity = minDequeCapacity
+	}
+	list.resize(capacity)
+}
+
+func (list *ledisList) shrink() {
+	if len(list.buf) > minDequeCapacity && list.size <= len(list.buf)/4 {
+		list.resize(len(list.buf) / 2)
+	}
+}
+
+func (list *ledisList) resize(capacity int) {
+	buf := make([]string, capacity)
+	for i := 0; i < list.size; i++ {
+		buf[i] = list.Index(i)
+	}
+	list.buf = buf
+	list.head = 0
+}
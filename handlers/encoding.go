@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// default limits of the compact encodings, the same as Redis
+const (
+	defaultListMaxListpackSize   = -2
+	defaultSetMaxIntsetEntries   = 512
+	defaultSetMaxListpackEntries = 128
+	defaultSetMaxListpackValue   = 64
+)
+
+// sharedIntegerCount strings holding the integers from 0 are shared by all
+// the keys set to them, like the shared integer objects of Redis
+const sharedIntegerCount = 10000
+
+var sharedIntegers = func() []string {
+	integers := make([]string, sharedIntegerCount)
+	for i := range integers {
+		integers[i] = strconv.Itoa(i)
+	}
+	return integers
+}()
+
+// encodingLimits are the limits over which collections leave their compact
+// encoding, see the list-max-* and set-max-* settings
+type encodingLimits struct {
+	// listMaxListpackSize is the maximum number of elements of a compact
+	// list when positive, and when negative the maximum size of its
+	// listpack: -1 for 4kb, -2 for 8kb... up to -5 for 64kb
+	listMaxListpackSize   int
+	setMaxIntsetEntries   int
+	setMaxListpackEntries int
+	setMaxListpackValue   int
+}
+
+func (store *LedisStore) limits() encodingLimits {
+	return encodingLimits{
+		listMaxListpackSize:   int(atomic.LoadInt32(&store.listMaxListpackSize)),
+		setMaxIntsetEntries:   int(atomic.LoadInt32(&store.setMaxIntsetEntries)),
+		setMaxListpackEntries: int(atomic.LoadInt32(&store.setMaxListpackEntries)),
+		setMaxListpackValue:   int(atomic.LoadInt32(&store.setMaxListpackValue)),
+	}
+}
+
+// fitsList reports whether the compact list stays within the limits
+func (limits encodingLimits) fitsList(list *ledisList) bool {
+	if limits.listMaxListpackSize > 0 {
+		return list.Len() <= limits.listMaxListpackSize
+	}
+	return len(list.packed) <= 4096<<(-limits.listMaxListpackSize-1)
+}
+
+// fitList expands the compact list once it is over the limits. Commands
+// adding many elements call it after each one, so that they are not
+// quadratic.
+func (limits encodingLimits) fitList(list *ledisList) {
+	if list.compact && !limits.fitsList(list) {
+		list.expand()
+	}
+}
+
+// fitsListpack reports whether the members of set can be packed in a
+// listpack
+func (limits encodingLimits) fitsListpack(set *ledisSet) bool {
+	if set.Len() > limits.setMaxListpackEntries {
+		return false
+	}
+	fits := true
+	set.Each(func(member string) bool {
+		fits = len(member) <= limits.setMaxListpackValue
+		return fits
+	})
+	return fits
+}
+
+// encode converts the collection of val to a less compact encoding when it
+// is over limits, and shares the strings holding small integers. Values are
+// encoded as they are created, see put, and collections again after each
+// change, see resized.
+func (val LedisData) encode(limits encodingLimits) LedisData {
+	switch val.DataType {
+	case TypeString:
+		if n, ok := parseInteger(*val.StringData); ok && n >= 0 && n < sharedIntegerCount {
+			val.StringData = &sharedIntegers[n]
+		}
+	case TypeList:
+		limits.fitList(val.ListData)
+	default:
+		set := val.SetData
+		switch {
+		case set.encoding == setIntset && set.Len() > limits.setMaxIntsetEntries:
+			if limits.fitsListpack(set) {
+				set.convert(setListpack)
+			} else {
+				set.convert(setHashtable)
+			}
+		case set.encoding == setListpack && !limits.fitsListpack(set):
+			set.convert(setHashtable)
+		}
+	}
+	return val
+}
+
+// shared reports whether str is one of the shared integers
+func shared(str *string) bool {
+	n, ok := parseInteger(*str)
+	return ok && n >= 0 && n < sharedIntegerCount && str == &sharedIntegers[n]
+}
+
+// encodingName names the representation of val, as OBJECT ENCODING does
+func encodingName(val LedisData) string {
+	switch val.DataType {
+	case TypeString:
+		if _, ok := parseInteger(*val.StringData); ok {
+			return "int"
+		}
+		return "raw"
+	case TypeList:
+		if val.ListData.compact {
+			return "listpack"
+		}
+		return "ringbuffer"
+	default:
+		return []string{setIntset: "intset", setListpack: "listpack", setHashtable: "hashtable"}[val.SetData.encoding]
+	}
+}
@@ -0,0 +1,164 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCompactEncodings(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`CONFIG GET set-max-*`, "set-max-intset-entries\r\n512\r\nset-max-listpack-entries\r\n128\r\nset-max-listpack-value\r\n64\r\n", ""},
+		{`CONFIG GET list-max-listpack-size`, "list-max-listpack-size\r\n-2\r\n", ""},
+		{`CONFIG SET list-max-listpack-size 0`, "ERROR: invalid argument '0' for CONFIG SET 'list-max-listpack-size': argument must be between -5 and -1 or positive", ""},
+		{`CONFIG SET set-max-intset-entries -1`, "ERROR: invalid argument '-1' for CONFIG SET 'set-max-intset-entries': argument must be a non negative number", ""},
+
+		// integers are shared up to 9999
+		{`SET shared 123`, "OK", ""},
+		{`OBJECT ENCODING shared`, "int", ""},
+		{`OBJECT REFCOUNT shared`, "2147483647", ""},
+		{`MEMORY USAGE shared`, "102", "Shared integers only cost their key"},
+		{`SET int 123456`, "OK", ""},
+		{`OBJECT ENCODING int`, "int", ""},
+		{`OBJECT REFCOUNT int`, "1", ""},
+		{`SET str 0123`, "OK", ""},
+		{`OBJECT ENCODING str`, "raw", "Only canonical integers are integers"},
+		{`GET shared`, "123", ""},
+
+		// sets of integers are intsets, until a member is not an integer
+		{`SADD ints 3 1 2 1`, "3", ""},
+		{`OBJECT ENCODING ints`, "intset", ""},
+		{`MEMORY USAGE ints`, "124", ""},
+		{`SISMEMBER ints 2`, "1", ""},
+		{`SISMEMBER ints 02`, "0", ""},
+		{`SREM ints 2 5`, "1", ""},
+		{`SADD ints a`, "1", ""},
+		{`OBJECT ENCODING ints`, "listpack", ""},
+		{`SCARD ints`, "3", ""},
+		{`SMISMEMBER ints 1 2 3 a`, "1\r\n0\r\n1\r\n1\r\n", ""},
+
+		// then a hashtable past the limits
+		{`CONFIG SET set-max-listpack-entries 4`, "OK", ""},
+		{`SADD ints b`, "1", ""},
+		{`OBJECT ENCODING ints`, "listpack", ""},
+		{`SADD ints c`, "1", ""},
+		{`OBJECT ENCODING ints`, "hashtable", ""},
+		{`SREM ints a b c`, "3", ""},
+		{`OBJECT ENCODING ints`, "hashtable", "Sets never convert back"},
+		{`SMISMEMBER ints 1 3 a`, "1\r\n1\r\n0\r\n", ""},
+		{`CONFIG SET set-max-intset-entries 2`, "OK", ""},
+		{`SADD nums 1 2`, "2", ""},
+		{`OBJECT ENCODING nums`, "intset", ""},
+		{`SADD nums 3`, "1", ""},
+		{`OBJECT ENCODING nums`, "listpack", ""},
+		{`CONFIG SET set-max-listpack-value 3`, "OK", ""},
+		{`SADD words abc`, "1", ""},
+		{`OBJECT ENCODING words`, "listpack", ""},
+		{`SMOVE words nums abcd`, "0", ""},
+		{`SADD words abcd`, "1", ""},
+		{`OBJECT ENCODING words`, "hashtable", ""},
+		{`SUNIONSTORE union nums`, "3", ""},
+		{`OBJECT ENCODING union`, "listpack", "New sets start as compact as they can"},
+		{`SUNIONSTORE union nums words`, "5", ""},
+		{`OBJECT ENCODING union`, "hashtable", ""},
+		{`SMOVE nums words 3`, "1", ""},
+		{`SREM nums 1`, "1", ""},
+		{`SPOP nums`, "2", ""},
+		{`EXISTS nums`, "0", ""},
+
+		// lists are packed until they reach list-max-listpack-size
+		{`CONFIG SET list-max-listpack-size 3`, "OK", ""},
+		{`RPUSH list b c`, "2", ""},
+		{`LPUSH list a`, "3", ""},
+		{`OBJECT ENCODING list`, "listpack", ""},
+		{`MEMORY USAGE list`, "106", ""},
+		{`LINSERT list BEFORE c bb`, "4", ""},
+		{`OBJECT ENCODING list`, "ringbuffer", ""},
+		{`LRANGE list 0 -1`, "a\r\nb\r\nbb\r\nc\r\n", ""},
+		{`RPUSH small x y z`, "3", ""},
+		{`LSET small 1 yy`, "OK", ""},
+		{`LINSERT small AFTER x w`, "4", ""},
+		{`LRANGE small 0 -1`, "x\r\nw\r\nyy\r\nz\r\n", ""},
+		{`CONFIG SET list-max-listpack-size 8`, "OK", ""},
+		{`RPUSH packed a b a c a`, "5", ""},
+		{`LREM packed -2 a`, "2", ""},
+		{`LRANGE packed 0 -1`, "a\r\nb\r\nc\r\n", ""},
+		{`LPOS packed c`, "2", ""},
+		{`LINDEX packed -1`, "c", ""},
+		{`LTRIM packed 1 -1`, "OK", ""},
+		{`LMOVE packed small LEFT RIGHT`, "b", ""},
+		{`RPOP packed`, "c", ""},
+		{`EXISTS packed`, "0", ""},
+		{`OBJECT ENCODING small`, "ringbuffer", ""},
+		{`CONFIG SET list-max-listpack-size -1`, "OK", ""},
+		{`RPUSH sized a`, "1", ""},
+		{`OBJECT ENCODING sized`, "listpack", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	// the negative sizes limit the bytes of the listpack, 4kb for -1
+	big := strings.Repeat("x", 4000)
+	g.Expect(SendCommand(`RPUSH sized ` + big)).To(Equal("2"))
+	g.Expect(SendCommand(`OBJECT ENCODING sized`)).To(Equal("listpack"))
+	g.Expect(SendCommand(`RPUSH sized ` + big)).To(Equal("3"))
+	g.Expect(SendCommand(`OBJECT ENCODING sized`)).To(Equal("ringbuffer"))
+	g.Expect(SendCommand(`LINDEX sized 0`)).To(Equal("a"))
+
+	// compact values survive snapshots and copies
+	g.Expect(SendCommand(`SAVE`)).To(Equal("OK"))
+	g.Expect(SendCommand(`FLUSHALL`)).To(Equal("OK"))
+	g.Expect(SendCommand(`RESTORE`)).To(Equal("OK"))
+	g.Expect(SendCommand(`SMISMEMBER ints 1 3`)).To(Equal("1\r\n1\r\n"))
+	g.Expect(SendCommand(`LRANGE small 0 -1`)).To(Equal("x\r\nw\r\nyy\r\nz\r\nb\r\n"))
+	g.Expect(SendCommand(`COPY small copied`)).To(Equal("1"))
+	g.Expect(SendCommand(`OBJECT ENCODING copied`)).To(Equal("listpack"))
+	g.Expect(SendCommand(`COPY ints copied-set`)).To(Equal("1"))
+	g.Expect(SendCommand(`SCARD copied-set`)).To(Equal("2"))
+	g.Expect(SendCommand(`GET shared`)).To(Equal("123"))
+}
+
+func TestCompactEncodingsBulkInsert(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	// a command adding many elements converts as soon as it goes over the
+	// limits, rather than inserting each of them in a listpack
+	const n = 100000
+	ints, members := make([]string, n), make([]string, n)
+	for i := range members {
+		ints[i] = strconv.Itoa(i)
+		members[i] = "member" + ints[i]
+	}
+	start := time.Now()
+	g.Expect(SendCommand(`LPUSH list ` + strings.Join(members, " "))).To(Equal(strconv.Itoa(n)))
+	g.Expect(SendCommand(`RPUSH list ` + strings.Join(members, " "))).To(Equal(strconv.Itoa(2 * n)))
+	g.Expect(SendCommand(`SADD set ` + strings.Join(members, " "))).To(Equal(strconv.Itoa(n)))
+	g.Expect(SendCommand(`SADD ints ` + strings.Join(ints, " "))).To(Equal(strconv.Itoa(n)))
+	g.Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second), "Inserts stay linear")
+
+	g.Expect(SendCommand(`OBJECT ENCODING list`)).To(Equal("ringbuffer"))
+	g.Expect(SendCommand(`LINDEX list 0`)).To(Equal(members[n-1]))
+	g.Expect(SendCommand(`LINDEX list -1`)).To(Equal(members[n-1]))
+	g.Expect(SendCommand(`OBJECT ENCODING set`)).To(Equal("hashtable"))
+	g.Expect(SendCommand(`OBJECT ENCODING ints`)).To(Equal("hashtable"))
+	g.Expect(SendCommand(`SMISMEMBER ints 0 99999 100000`)).To(Equal("1\r\n1\r\n0\r\n"))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import "time"
+
+// WithExecutor runs the commands one at a time on a single executor
+// goroutine, an event loop like the one of Redis, instead of locking the
+// shards of their keys. Commands then have strict serial semantics and no
+// key is ever locked. Blocking commands wait off the executor.
+func WithExecutor() StoreOption {
+	return func(store *LedisStore) {
+		store.serial = true
+	}
+}
+
+// execute runs fn: right away in mutex mode, where fn locks what it uses,
+// and on the executor in serial mode. Once the store is closed, fn runs on
+// the calling goroutine, still one at a time. A panic of fn on the executor
+// is raised again on the calling goroutine, so that it fails the caller
+// like in mutex mode instead of stopping the executor.
+func (store *LedisStore) execute(fn func()) {
+	if !store.serial {
+		fn()
+		return
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	var panicked interface{}
+	select {
+	case store.jobs <- func() {
+		defer close(done)
+		defer func() {
+			panicked = recover()
+		}()
+		store.metrics.lockWaited(lockExecutor, time.Since(start))
+		fn()
+	}:
+		<-done
+		if panicked != nil {
+			panic(panicked)
+		}
+	case <-store.ctx.Done():
+		store.closedLock.Lock()
+		defer store.closedLock.Unlock()
+		store.metrics.lockWaited(lockExecutor, time.Since(start))
+		fn()
+	}
+}
+
+// enter makes the calling goroutine the only one running commands, until it
+// calls the returned function. In serial mode the executor waits meanwhile,
+// this is how the Go API runs without going through closures.
+func (db *ledisDB) enter() func() {
+	entered := make(chan struct{})
+	done := make(chan struct{})
+	go db.execute(func() {
+		close(entered)
+		<-done
+	})
+	<-entered
+	return func() {
+		db.serveReady()
+		close(done)
+	}
+}
+
+// executor runs the commands, removes the expired keys and samples the
+// stats in serial mode, until the store is closed
+func (store *LedisStore) executor() {
+	defer store.wg.Done()
+
+	ticker := time.NewTicker(store.expireInterval)
+	defer ticker.Stop()
+	sampler := time.NewTicker(opsSampleInterval)
+	defer sampler.Stop()
+	for {
+		select {
+		case <-store.ctx.Done():
+			return
+		case job := <-store.jobs:
+			job()
+		case <-ticker.C:
+			store.removeExpired()
+		case <-sampler.C:
+			store.stats.sampleOps()
+		}
+	}
+}
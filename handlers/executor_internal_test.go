@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestExecutorPanic(t *testing.T) {
+	store := NewLedisStore(WithExecutor())
+	defer store.Close()
+	g := NewGomegaWithT(t)
+
+	raised := func() (recovered interface{}) {
+		defer func() {
+			recovered = recover()
+		}()
+		store.execute(func() {
+			panic("boom")
+		})
+		return nil
+	}
+	g.Expect(raised()).To(Equal("boom"), "The panic is raised on the calling goroutine")
+
+	ran := false
+	store.execute(func() {
+		ran = true
+	})
+	g.Expect(ran).To(BeTrue(), "The executor keeps running the jobs")
+}
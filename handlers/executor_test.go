@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestExecutor(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithExecutor(), handlers.WithExpireInterval(10*time.Millisecond))
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET testkey 123`, "OK", ""},
+		{`GET testkey`, "123", ""},
+		{`RPUSH queue a b`, "2", ""},
+		{`BLPOP queue 1`, "queue\r\na\r\n", ""},
+		{`SMOVE no-exist set a`, "0", ""},
+		{`SELECT 1`, "OK", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	// blocked clients wait off the executor, which serves the others
+	blocked := SendCommandAsync(`BLMOVE src dest LEFT RIGHT 0`)
+	timedOut := SendCommandAsync(`BRPOP other 0.1`)
+	g.Eventually(timedOut).Should(Receive(Equal("(nil)")))
+	g.Expect(SendCommand(`RPUSH src x`)).To(Equal("1"))
+	g.Eventually(blocked).Should(Receive(Equal("x")))
+	g.Expect(SendCommand(`LRANGE dest 0 -1`)).To(Equal("x\r\n"))
+
+	// the Go API runs in turn with the commands
+	db, _ := store.DB(0)
+	length, err := db.RPush("queue", []byte("c"))
+	g.Expect(length).To(Equal(2))
+	g.Expect(err).To(BeNil())
+	blocked = SendCommandAsync(`BLPOP api-queue 0`)
+	time.Sleep(50 * time.Millisecond)
+	db.LPush("api-queue", []byte("y"))
+	g.Eventually(blocked).Should(Receive(Equal("api-queue\r\ny\r\n")))
+
+	g.Expect(SendCommand(`EXPIRE testkey 1`)).To(Equal("1"))
+	g.Eventually(func() string { return SendCommand(`GET testkey`) }, 3*time.Second, 50*time.Millisecond).
+		Should(Equal("key not found"), "The executor removes expired keys")
+
+	store.Close()
+	g.Expect(SendCommand(`SET testkey 1`)).To(Equal("OK"), "Commands still run once the store is closed")
+	g.Expect(db.Exists("testkey")).To(Equal(1))
+}
+
+// benchmarkCommands runs SET and GET commands on many keys from parallel
+// clients, without the network
+func benchmarkCommands(b *testing.B, options ...handlers.StoreOption) {
+	store := handlers.NewLedisStore(options...)
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+
+	var clients int64
+	b.RunParallel(func(pb *testing.PB) {
+		id := atomic.AddInt64(&clients, 1)
+		for i := 0; pb.Next(); i++ {
+			cmd := fmt.Sprintf("SET key:%d:%d %d", id, i%1000, i)
+			if i%2 == 1 {
+				cmd = fmt.Sprintf("GET key:%d:%d", id, i%1000)
+			}
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cmd))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+func BenchmarkMutexMode(b *testing.B) {
+	benchmarkCommands(b)
+}
+
+func BenchmarkExecutorMode(b *testing.B) {
+	benchmarkCommands(b, handlers.WithExecutor())
+}
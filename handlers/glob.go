@@ -0,0 +1,105 @@
+package handlers
+
+// globMatch reports whether str matches the Redis style glob pattern, where
+// '*' matches any sequence of bytes, '?' matches exactly one byte, "[abc]"
+// matches one of the listed bytes and "[^abc]" any byte not listed, "[a-z]"
+// matches one byte in the range, and '\' escapes the next byte, both outside
+// and inside brackets.
+func globMatch(pattern, str string) bool {
+	p, s := 0, 0
+	// on a mismatch, backtrack to the last star and let it match one more
+	// byte, which keeps matching O(len(pattern) * len(str)) however many
+	// stars the pattern has
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, s
+				p++
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if end, ok := matchBracket(pattern, p+1, str[s]); ok {
+					p = end + 1
+					s++
+					continue
+				}
+			case '\\':
+				next := p
+				if p+1 < len(pattern) {
+					next++
+				}
+				if pattern[next] == str[s] {
+					p = next + 1
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, s = starP+1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchBracket matches c against the bracket expression starting at
+// pattern[start], right after the '['. It returns the index of the closing
+// ']' (or the last index of an unterminated expression) and whether c matched.
+func matchBracket(pattern string, start int, c byte) (int, bool) {
+	p := start
+	negate := false
+	if p < len(pattern) && pattern[p] == '^' {
+		negate = true
+		p++
+	}
+
+	match := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				match = true
+			}
+		}
+	}
+	if p == len(pattern) {
+		// unterminated bracket, like Redis treat the end of the pattern
+		// as the closing bracket
+		p--
+	}
+
+	if negate {
+		match = !match
+	}
+	return p, match
+}
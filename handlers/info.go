@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ledisVersion is the version reported by INFO
+const ledisVersion = "0.4.0"
+
+// INFO computes ops per second over the last opsSamples samples, taken
+// every opsSampleInterval like Redis does
+const (
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+)
+
+// infoSections are the sections of INFO, in the order they are reported
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "keyspace"}
+
+// storeStats are the counters reported by INFO, updated atomically
+type storeStats struct {
+	started time.Time
+
+	connectedClients int64
+	totalConnections int64
+	commands         int64
+	keyspaceHits     int64
+	keyspaceMisses   int64
+	expiredKeys      int64
+	evictedKeys      int64
+
+	// dirty is the number of changes since the last SAVE, lastSave its unix
+	// time (the start time before the first one) and lastSaveFailed is set
+	// when it failed
+	dirty          int64
+	lastSave       int64
+	lastSaveFailed int32
+	saves          int64
+
+	opsLock         sync.Mutex
+	opsLastTime     time.Time
+	opsLastCommands int64
+	opsSamples      [opsSamples]float64
+	opsNext         int
+}
+
+func newStoreStats() storeStats {
+	now := time.Now()
+	return storeStats{started: now, lastSave: now.Unix(), opsLastTime: now}
+}
+
+// connect counts a client served by a handler, until the returned function
+// is called
+func (stats *storeStats) connect() func() {
+	atomic.AddInt64(&stats.connectedClients, 1)
+	atomic.AddInt64(&stats.totalConnections, 1)
+	return func() {
+		atomic.AddInt64(&stats.connectedClients, -1)
+	}
+}
+
+// sampleOps samples the commands processed per second since the previous
+// sample
+func (stats *storeStats) sampleOps() {
+	stats.opsLock.Lock()
+	defer stats.opsLock.Unlock()
+
+	now := time.Now()
+	commands := atomic.LoadInt64(&stats.commands)
+	if elapsed := now.Sub(stats.opsLastTime).Seconds(); elapsed > 0 {
+		stats.opsSamples[stats.opsNext] = float64(commands-stats.opsLastCommands) / elapsed
+		stats.opsNext = (stats.opsNext + 1) % opsSamples
+	}
+	stats.opsLastTime, stats.opsLastCommands = now, commands
+}
+
+func (stats *storeStats) opsPerSec() int {
+	stats.opsLock.Lock()
+	defer stats.opsLock.Unlock()
+
+	sum := float64(0)
+	for _, sample := range stats.opsSamples {
+		sum += sample
+	}
+	return int(sum / opsSamples)
+}
+
+// countReads counts the keyspace hits and misses of a command reading keys.
+// The shard locks of keys must be held.
+func (db *ledisDB) countReads(keys ...string) {
+	for _, key := range keys {
+		if _, ok := db.peek(key); ok {
+			atomic.AddInt64(&db.stats.keyspaceHits, 1)
+		} else {
+			atomic.AddInt64(&db.stats.keyspaceMisses, 1)
+		}
+	}
+}
+
+// Info returns the sections of the server information, all of them when
+// none is given, in the "field:value" format of Redis
+func (db *ledisDB) Info(sections []string) reply {
+	selected := map[string]bool{}
+	for _, section := range sections {
+		switch section = strings.ToLower(section); section {
+		case "all", "everything", "default":
+			for _, name := range infoSections {
+				selected[name] = true
+			}
+		default:
+			selected[section] = true
+		}
+	}
+
+	var sb strings.Builder
+	for _, name := range infoSections {
+		if len(sections) > 0 && !selected[name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + strings.ToUpper(name[:1]) + name[1:] + "\r\n")
+		for _, field := range db.infoSection(name) {
+			sb.WriteString(field[0] + ":" + field[1] + "\r\n")
+		}
+	}
+	return bulkReply(sb.String())
+}
+
+func (db *ledisDB) infoSection(name string) [][2]string {
+	stats := &db.stats
+	load := func(counter *int64) string {
+		return strconv.FormatInt(atomic.LoadInt64(counter), 10)
+	}
+
+	switch name {
+	case "server":
+		mode := "shards"
+		if db.serial {
+			mode = "executor"
+		}
+		uptime := time.Since(stats.started)
+		return [][2]string{
+			{"ledis_version", ledisVersion},
+			{"go_version", runtime.Version()},
+			{"os", runtime.GOOS + " " + runtime.GOARCH},
+			{"arch_bits", strconv.Itoa(strconv.IntSize)},
+			{"process_id", strconv.Itoa(os.Getpid())},
+			{"concurrency", mode},
+			{"uptime_in_seconds", strconv.Itoa(int(uptime / time.Second))},
+			{"uptime_in_days", strconv.Itoa(int(uptime / (24 * time.Hour)))},
+		}
+	case "clients":
+		blocked := int32(0)
+		for _, d := range db.dbs {
+			blocked += atomic.LoadInt32(&d.waiting)
+		}
+		db.pubsub.lock.RLock()
+		subscribers := len(db.pubsub.subscribers)
+		db.pubsub.lock.RUnlock()
+		return [][2]string{
+			{"connected_clients", load(&stats.connectedClients)},
+			{"blocked_clients", strconv.Itoa(int(blocked))},
+			{"pubsub_clients", strconv.Itoa(subscribers)},
+		}
+	case "memory":
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		used := atomic.LoadInt64(&db.used)
+		maxMemory := atomic.LoadInt64(&db.maxMemory)
+		return [][2]string{
+			{"used_memory", strconv.FormatInt(used, 10)},
+			{"used_memory_human", humanBytes(used)},
+			{"used_memory_heap", strconv.FormatUint(mem.HeapAlloc, 10)},
+			{"used_memory_heap_human", humanBytes(int64(mem.HeapAlloc))},
+			{"used_memory_runtime", strconv.FormatUint(mem.Sys, 10)},
+			{"maxmemory", strconv.FormatInt(maxMemory, 10)},
+			{"maxmemory_human", humanBytes(maxMemory)},
+			{"maxmemory_policy", evictionPolicies[atomic.LoadInt32(&db.maxMemoryPolicy)]},
+			{"mem_allocator", "go"},
+		}
+	case "persistence":
+		status := "ok"
+		if atomic.LoadInt32(&stats.lastSaveFailed) != 0 {
+			status = "err"
+		}
+		return [][2]string{
+			{"loading", "0"},
+			{"rdb_changes_since_last_save", load(&stats.dirty)},
+			{"rdb_bgsave_in_progress", "0"},
+			{"rdb_last_save_time", load(&stats.lastSave)},
+			{"rdb_last_bgsave_status", status},
+			{"rdb_saves", load(&stats.saves)},
+		}
+	case "stats":
+		db.pubsub.lock.RLock()
+		channels, patterns := len(db.pubsub.channels), len(db.pubsub.patterns)
+		db.pubsub.lock.RUnlock()
+		return [][2]string{
+			{"total_connections_received", load(&stats.totalConnections)},
+			{"total_commands_processed", load(&stats.commands)},
+			{"instantaneous_ops_per_sec", strconv.Itoa(stats.opsPerSec())},
+			{"expired_keys", load(&stats.expiredKeys)},
+			{"evicted_keys", load(&stats.evictedKeys)},
+			{"keyspace_hits", load(&stats.keyspaceHits)},
+			{"keyspace_misses", load(&stats.keyspaceMisses)},
+			{"pubsub_channels", strconv.Itoa(channels)},
+			{"pubsub_patterns", strconv.Itoa(patterns)},
+		}
+	default:
+		return db.keyspaceInfo()
+	}
+}
+
+// keyspaceInfo returns the number of keys, of keys with an expiration and
+// their average TTL in milliseconds, of every non empty database
+func (db *ledisDB) keyspaceInfo() [][2]string {
+	unlock := rlockAll(db.dbs...)
+	defer unlock()
+
+	now := time.Now().Unix()
+	fields := [][2]string{}
+	for _, d := range db.dbs {
+		keys := d.size()
+		if keys == 0 {
+			continue
+		}
+		expires, ttl := 0, int64(0)
+		for _, s := range d.shards {
+			for _, expireTime := range s.ExpireTime {
+				expires++
+				if expireTime > now {
+					ttl += expireTime - now
+				}
+			}
+		}
+		avgTTL := int64(0)
+		if expires > 0 {
+			avgTTL = ttl * 1000 / int64(expires)
+		}
+		fields = append(fields, [2]string{"db" + strconv.Itoa(d.index),
+			fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, expires, avgTTL)})
+	}
+	return fields
+}
+
+// humanBytes formats a number of bytes like Redis does, as in 1.50M
+func humanBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(size, 'f', 2, 64) + units[unit]
+}
@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// info parses the fields of INFO section
+func info(section string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(SendCommand(`INFO `+section), "\r\n") {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithExpireInterval(10 * time.Millisecond))
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	all := SendCommand(`INFO`)
+	for _, header := range []string{"# Server\r\n", "\r\n\r\n# Clients\r\n", "\r\n\r\n# Memory\r\n", "\r\n\r\n# Persistence\r\n", "\r\n\r\n# Stats\r\n", "\r\n\r\n# Keyspace\r\n"} {
+		g.Expect(all).To(ContainSubstring(header))
+	}
+	g.Expect(SendCommand(`INFO keyspace`)).To(Equal("# Keyspace\r\n"), "Empty databases are not listed")
+	g.Expect(SendCommand(`INFO KEYSPACE server`)).To(HavePrefix("# Server\r\nledis_version:"))
+	g.Expect(SendCommand(`INFO nothing`)).To(Equal(""))
+	g.Expect(info("server")).To(HaveKeyWithValue("concurrency", "shards"))
+	g.Expect(info("clients")).To(HaveKeyWithValue("connected_clients", "1"), "The client asking")
+
+	// keyspace, hits and misses, changes since the last save
+	tests := []ValidateExactTest{
+		{`SET key 1`, "OK", ""},
+		{`EXPIRE key 100`, "100", ""},
+		{`RPUSH list a`, "1", ""},
+		{`GET key`, "1", ""},
+		{`GET missing`, "key not found", ""},
+		{`LRANGE list 0 -1`, "a\r\n", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+	g.Expect(SendDBCommand("2", `SADD set a`)).To(Equal("1"))
+	keyspace := info("keyspace")
+	g.Expect(keyspace).To(HaveLen(2))
+	g.Expect(keyspace["db0"]).To(MatchRegexp(`^keys=2,expires=1,avg_ttl=(99|100)\d{3}$`))
+	g.Expect(keyspace["db2"]).To(Equal("keys=1,expires=0,avg_ttl=0"))
+	stats := info("stats")
+	g.Expect(stats).To(HaveKeyWithValue("keyspace_hits", "2"))
+	g.Expect(stats).To(HaveKeyWithValue("keyspace_misses", "1"))
+	commands, _ := strconv.Atoi(stats["total_commands_processed"])
+	g.Expect(commands).To(BeNumerically(">=", len(tests)))
+	g.Expect(info("persistence")).To(HaveKeyWithValue("rdb_changes_since_last_save", "4"))
+	g.Expect(SendCommand(`SAVE`)).To(Equal("OK"))
+	persistence := info("persistence")
+	g.Expect(persistence).To(HaveKeyWithValue("rdb_changes_since_last_save", "0"))
+	g.Expect(persistence).To(HaveKeyWithValue("rdb_saves", "1"))
+	g.Expect(persistence).To(HaveKeyWithValue("rdb_last_bgsave_status", "ok"))
+	g.Expect(persistence["rdb_last_save_time"]).To(Equal(strconv.FormatInt(time.Now().Unix(), 10)))
+
+	// expired and evicted keys, blocked clients and ops per second
+	g.Expect(SendCommand(`EXPIRE list 1`)).To(Equal("1"))
+	g.Eventually(func() map[string]string { return info("stats") }, 3*time.Second, 50*time.Millisecond).
+		Should(HaveKeyWithValue("expired_keys", "1"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory-policy allkeys-random`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory 1`)).To(Equal("OK"))
+	g.Expect(SendCommand(`SET other 1`)).To(Equal("OK"))
+	g.Expect(info("stats")).To(HaveKeyWithValue("evicted_keys", "2"))
+	memory := info("memory")
+	g.Expect(memory).To(HaveKeyWithValue("maxmemory", "1"))
+	g.Expect(memory).To(HaveKeyWithValue("maxmemory_human", "1B"))
+	g.Expect(memory).To(HaveKeyWithValue("maxmemory_policy", "allkeys-random"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory 0`)).To(Equal("OK"))
+
+	blocked := SendCommandAsync(`BLPOP queue 0`)
+	g.Eventually(func() map[string]string { return info("clients") }).Should(HaveKeyWithValue("blocked_clients", "1"))
+	g.Expect(info("clients")).To(HaveKeyWithValue("connected_clients", "2"))
+	g.Expect(SendCommand(`RPUSH queue a`)).To(Equal("1"))
+	g.Eventually(blocked).Should(Receive(Equal("queue\r\na\r\n")))
+
+	g.Eventually(func() string { return info("stats")["instantaneous_ops_per_sec"] }).ShouldNot(Equal("0"))
+}
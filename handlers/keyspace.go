@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+func keyspaceCommand(db *ledisDB, name string, cmd *command) reply {
+	switch name {
+	case "TYPE":
+		if len(cmd.Args) != 1 {
+			return errorReply(fmt.Errorf("TYPE expects 1 argument"))
+		}
+		return db.Type(cmd.Args[0])
+	case "EXISTS", "TOUCH":
+		if len(cmd.Args) < 1 {
+			return errorReply(fmt.Errorf("%s expects at least 1 argument", name))
+		}
+		if name == "EXISTS" {
+			return db.Exists(cmd.Args)
+		}
+		return db.Touch(cmd.Args)
+	case "RENAME", "RENAMENX":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("%s expects 2 arguments", name))
+		}
+		return db.Rename(cmd.Args[0], cmd.Args[1], name == "RENAMENX")
+	case "COPY":
+		return copyCommand(db, cmd)
+	case "RANDOMKEY":
+		if len(cmd.Args) != 0 {
+			return errorReply(fmt.Errorf("RANDOMKEY expects no argument"))
+		}
+		return db.Randomkey()
+	default:
+		if len(cmd.Args) != 0 {
+			return errorReply(fmt.Errorf("DBSIZE expects no argument"))
+		}
+		return db.Dbsize()
+	}
+}
+
+// copyCommand parses COPY source destination [DB destination-db] [REPLACE]
+func copyCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 2 {
+		return errorReply(fmt.Errorf("COPY expects at least 2 arguments"))
+	}
+	index, replace := db.index, false
+	for i := 2; i < len(cmd.Args); i++ {
+		switch option := strings.ToUpper(cmd.Args[i]); {
+		case option == "REPLACE":
+			replace = true
+		case option == "DB" && i+1 < len(cmd.Args):
+			i++
+			destIndex, err := db.parseDB(cmd.Args[i])
+			if err != nil {
+				return errorReply(err)
+			}
+			index = destIndex
+		default:
+			return errorReply(fmt.Errorf("unknown COPY option: %s", cmd.Args[i]))
+		}
+	}
+	return db.Copy(cmd.Args[0], cmd.Args[1], index, replace)
+}
+
+func typeName(dataType ledisType) string {
+	switch dataType {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	default:
+		return "set"
+	}
+}
+
+// Type returns the type of the value at key, none when it does not exist
+func (db *ledisDB) Type(key string) reply {
+	return statusReply(db.api().Type(key))
+}
+
+// Exists returns how many of keys exist, a key given twice counts twice
+func (db *ledisDB) Exists(keys []string) reply {
+	return intReply(db.api().Exists(keys...))
+}
+
+// Touch returns how many of keys exist, recording an access to them like
+// Exists does
+func (db *ledisDB) Touch(keys []string) reply {
+	unlock := db.rlockKeys(keys...)
+	defer unlock()
+
+	return intReply(db.countExisting(keys))
+}
+
+func (db *ledisDB) countExisting(keys []string) int {
+	count := 0
+	for _, key := range keys {
+		if _, ok := db.lookup(key); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// Rename moves the value at src and its expiration to dest, overwriting
+// dest. With onlyNew, dest is left alone if it exists and 0 is returned.
+func (db *ledisDB) Rename(src, dest string, onlyNew bool) reply {
+	unlock := db.lockKeys(src, dest)
+	defer unlock()
+
+	storeVal, ok := db.lookup(src)
+	if !ok {
+		return errorReply(fmt.Errorf("no such key"))
+	}
+	if _, exists := db.lookup(dest); exists && onlyNew {
+		return intReply(0)
+	}
+	if src != dest {
+		expireTime, expires := db.expireTime(src)
+		db.replaceKey(dest, storeVal, expireTime, expires)
+		db.remove(src)
+		db.notify(notifyGeneric, "rename_from", src)
+		db.notify(notifyGeneric, "rename_to", dest)
+		db.signalReady(dest)
+	}
+	if onlyNew {
+		return intReply(1)
+	}
+	return statusReply("OK")
+}
+
+// Copy copies the value at src and its expiration to dest in the database
+// at index, dest must not exist unless replace is set. It returns 1 if the
+// value was copied.
+func (db *ledisDB) Copy(src, dest string, index int, replace bool) reply {
+	destDB := db.dbs[index]
+	if src == dest && destDB == db {
+		return errorReply(fmt.Errorf("source and destination objects are the same"))
+	}
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := lockShards([]*shard{db.shardOf(src), destDB.shardOf(dest)})
+	defer unlock()
+
+	storeVal, ok := db.lookup(src)
+	if !ok {
+		return intReply(0)
+	}
+	if _, exists := destDB.lookup(dest); exists && !replace {
+		return intReply(0)
+	}
+
+	expireTime, expires := db.expireTime(src)
+	destDB.replaceKey(dest, storeVal.clone(), expireTime, expires)
+	destDB.notify(notifyGeneric, "copy_to", dest)
+	destDB.signalReady(dest)
+	return intReply(1)
+}
+
+// replaceKey stores val at key with the expiration of the key it comes
+// from, if it expires. The shard lock of key must be held.
+func (db *ledisDB) replaceKey(key string, val LedisData, expireTime int64, expires bool) {
+	db.put(key, val)
+	if expires {
+		db.setExpireTime(key, expireTime)
+	} else {
+		db.persist(key)
+	}
+}
+
+// Randomkey returns a key picked at random
+func (db *ledisDB) Randomkey() reply {
+	unlock := rlockAll(db)
+	defer unlock()
+
+	// start from a random shard, then like for sets rely on the random
+	// start of map iteration
+	start := rand.Intn(len(db.shards))
+	for i := range db.shards {
+		for key := range db.shards[(start+i)%len(db.shards)].Data {
+			return bulkReply(key)
+		}
+	}
+	return nilReply("empty")
+}
+
+func (db *ledisDB) Dbsize() reply {
+	unlock := rlockAll(db)
+	defer unlock()
+
+	return intReply(db.size())
+}
+
+// clone returns a deep copy of val, sharing no collection nor metadata with
+// it
+func (val LedisData) clone() LedisData {
+	val.meta = nil
+	switch val.DataType {
+	case TypeString:
+		str := *val.StringData
+		val.StringData = &str
+	case TypeList:
+		val.ListData = newLedisList(val.ListData.Values())
+	default:
+		val.SetData = val.SetData.clone()
+	}
+	return val
+}
@@ -0,0 +1,128 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestKeyspaceOps(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`RANDOMKEY`, "empty", ""},
+		{`DBSIZE`, "0", ""},
+		{`SET str 1`, "OK", ""},
+		{`RPUSH list a b`, "2", ""},
+		{`SADD set x y`, "2", ""},
+
+		// TYPE, EXISTS, TOUCH, DBSIZE
+		{`TYPE str`, "string", "Test TYPE"},
+		{`TYPE list`, "list", ""},
+		{`TYPE set`, "set", ""},
+		{`TYPE no-exist`, "none", ""},
+		{`EXISTS str no-exist list`, "2", "Test EXISTS"},
+		{`EXISTS str str`, "2", "Repeated keys count each time"},
+		{`EXISTS no-exist`, "0", ""},
+		{`TOUCH str set no-exist`, "2", "Test TOUCH"},
+		{`DBSIZE`, "3", "Test DBSIZE"},
+
+		// RENAME carries the TTL
+		{`EXPIRE str 100`, "100", ""},
+		{`RENAME str str2`, "OK", "Test RENAME"},
+		{`TTL str2`, "100", "RENAME carries the TTL"},
+		{`GET str2`, "1", ""},
+		{`EXISTS str`, "0", ""},
+		{`RENAME no-exist other`, "ERROR: no such key", ""},
+		{`RENAME list list`, "OK", ""},
+		{`RENAME str2 set`, "OK", "RENAME overwrites other types"},
+		{`TYPE set`, "string", ""},
+		{`TTL set`, "100", ""},
+		{`SADD set x y`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SADD set2 x y`, "2", ""},
+		{`RENAMENX set2 set`, "0", "Test RENAMENX"},
+		{`RENAMENX set2 set3`, "1", ""},
+		{`RENAMENX no-exist set4`, "ERROR: no such key", ""},
+		{`SET plain 1`, "OK", ""},
+		{`RENAME set plain`, "OK", ""},
+		{`TTL plain`, "100", ""},
+		{`SET str 1`, "OK", ""},
+		{`EXPIRE str 50`, "50", ""},
+		{`RENAME plain str`, "OK", ""},
+		{`TTL str`, "100", "The TTL of the destination is replaced"},
+		{`SET nottl 1`, "OK", ""},
+		{`RENAME nottl str`, "OK", ""},
+		{`TTL str`, "-1", ""},
+
+		// COPY
+		{`COPY list list2`, "1", "Test COPY"},
+		{`RPUSH list2 c`, "3", ""},
+		{`LRANGE list 0 -1`, "a\r\nb\r\n", "The copy shares nothing with the source"},
+		{`COPY set3 list2`, "0", "COPY does not overwrite without REPLACE"},
+		{`COPY set3 list2 REPLACE`, "1", ""},
+		{`TYPE list2`, "set", ""},
+		{`SREM set3 x`, "1", ""},
+		{`SCARD list2`, "2", ""},
+		{`COPY no-exist other`, "0", ""},
+		{`COPY list list`, "ERROR: source and destination objects are the same", ""},
+		{`COPY list list REPLACE`, "ERROR: source and destination objects are the same", ""},
+		{`EXPIRE list 100`, "100", ""},
+		{`COPY list list3`, "1", ""},
+		{`TTL list3`, "100", "COPY carries the TTL"},
+		{`COPY str str3`, "1", ""},
+		{`SET str 2`, "OK", ""},
+		{`GET str3`, "1", ""},
+
+		// DEL, UNLINK
+		{`DEL list list2 no-exist`, "2", "Test multi key DEL"},
+		{`DEL list`, "key not found", ""},
+		{`UNLINK list3 str3`, "2", "Test UNLINK"},
+		{`RPUSH list3 a`, "1", ""},
+		{`TTL list3`, "-1", "DEL removes the TTL"},
+	}
+	for _, test := range tests {
+		body := SendCommand(test.command)
+		g.Expect(body).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+
+	g.Expect([]string{"str", "set3", "list3"}).To(ContainElement(SendCommand(`RANDOMKEY`)))
+	g.Expect(SendRESP([]string{"TYPE", "str"}, []string{"RANDOMKEY"}, []string{"FLUSHDB"}, []string{"RANDOMKEY"})).To(
+		MatchRegexp(`^\+string\r\n\$\d+\r\n\w+\r\n\+OK\r\n\$-1\r\n$`))
+}
+
+func TestInvalidKeyspaceCommand(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{"TYPE", "ERROR: TYPE expects 1 argument", ""},
+		{"EXISTS", "ERROR: EXISTS expects at least 1 argument", ""},
+		{"TOUCH", "ERROR: TOUCH expects at least 1 argument", ""},
+		{"UNLINK", "ERROR: UNLINK expects at least 1 argument", ""},
+		{"RENAME a", "ERROR: RENAME expects 2 arguments", ""},
+		{"RENAMENX a b c", "ERROR: RENAMENX expects 2 arguments", ""},
+		{"COPY a", "ERROR: COPY expects at least 2 arguments", ""},
+		{"COPY a b DB", "ERROR: unknown COPY option: DB", ""},
+		{"COPY a b DB 99", "ERROR: DB index is out of range", ""},
+		{"COPY a b KEEP", "ERROR: unknown COPY option: KEEP", ""},
+		{"RANDOMKEY a", "ERROR: RANDOMKEY expects no argument", ""},
+		{"DBSIZE a", "ERROR: DBSIZE expects no argument", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
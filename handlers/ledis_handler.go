@@ -1,24 +1,32 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	shellquote "github.com/kballard/go-shellquote"
 )
 
+// LedisHandler serves the commands sent over HTTP to its store
 type LedisHandler struct {
+	store *LedisStore
 }
 
-var store *LedisStore
+func NewLedisHandler(store *LedisStore) *LedisHandler {
+	return &LedisHandler{store: store}
+}
 
 type ledisType int
 
@@ -30,190 +38,297 @@ const (
 
 type LedisData struct {
 	DataType   ledisType
-	SetData    *map[string]bool
-	ListData   *[]string
+	SetData    *ledisSet
+	ListData   *ledisList
 	StringData *string
-}
-
-type LedisStore struct {
-	Data       map[string]LedisData
-	ExpireTime map[string]int64
-	lock       *sync.RWMutex
-}
 
-func InitStore() {
-	store = &LedisStore{
-		Data:       make(map[string]LedisData),
-		ExpireTime: make(map[string]int64),
-		lock:       &sync.RWMutex{},
-	}
+	meta *keyMeta
 }
 
-func ExpiredCleaner() {
-	for {
-		time.Sleep(500 * time.Millisecond)
-		store.lock.RLock()
-
-		timeNow := time.Now().Unix()
-		for key, val := range store.ExpireTime {
-			if val-timeNow <= 0 {
-				delete(store.ExpireTime, key)
-				delete(store.Data, key)
-			}
-		}
-		store.lock.RUnlock()
-	}
+// ledisDB is a logical database, the state shared by all the databases is
+// in the embedded LedisStore
+type ledisDB struct {
+	// the keys, partitioned by hash
+	shards []*shard
+
+	// blockLock guards the clients blocked on list keys, it is taken after
+	// the shard locks
+	blockLock sync.Mutex
+	// clients blocked on list keys, in the order they will be served
+	blocked map[string][]*listWaiter
+	// keys pushed to while clients are blocked on them, served once the
+	// pushing command releases its locks
+	ready map[string]bool
+	// waiting is the number of blocked clients, read atomically so that
+	// pushes skip blockLock when there is none
+	waiting int32
+
+	// index of the database, as given to SELECT
+	index int
+
+	*LedisStore
 }
 
 func (h *LedisHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	defer h.store.stats.connect()()
 	body, _ := ioutil.ReadAll(r.Body)
-	bodyStr := string(body[:])
 
 	setHTTPStatus(w)
-	cmd, err := parseCommand(bodyStr)
+	cmds, format, err := parseRequest(r.Header.Get("Content-Type"), body)
 	if err != nil {
-		respError(w, err)
+		if format == formatRESP {
+			w.Header().Set("Content-Type", respContentType)
+			writeBody(w, errorReply(err).encodeRESP())
+			return
+		}
+		writeBody(w, errorReply(err).encodeText())
 		return
 	}
 
-	switch strings.ToUpper(cmd.Name) {
+	if len(cmds) == 1 && strings.ToUpper(cmds[0].Name) == "MONITOR" && len(cmds[0].Args) == 0 {
+		h.monitor(w, r, format)
+		return
+	}
+
+	c := &client{
+		store:      h.store,
+		ctx:        r.Context(),
+		addr:       r.RemoteAddr,
+		subscriber: r.Header.Get(subscriberHeader),
+	}
+	// pipelined commands run one after another, there is no atomicity
+	// guarantee: other clients' commands may be interleaved between them
+	replies := make([]reply, 0, len(cmds))
+	selected, err := h.store.requestDB(r)
+	if err != nil {
+		replies = append(replies, errorReply(err))
+	} else {
+		c.db = selected
+		for _, cmd := range cmds {
+			replies = append(replies, c.exec(cmd))
+		}
+		if c.db != selected {
+			// remember the selected database for the next requests
+			http.SetCookie(w, &http.Cookie{Name: dbCookie, Value: strconv.Itoa(c.db), Path: "/"})
+		}
+	}
+
+	switch format {
+	case formatRESP:
+		w.Header().Set("Content-Type", respContentType)
+		for _, rep := range replies {
+			writeBody(w, rep.encodeRESP())
+		}
+	case formatJSON:
+		results := make([]string, 0, len(replies))
+		for _, rep := range replies {
+			results = append(results, rep.encodeText())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	default:
+		writeBody(w, replies[0].encodeText())
+	}
+}
+
+func execCommand(c *client, cmd *command) reply {
+	db := c.store.dbs[c.db]
+	name := strings.ToUpper(cmd.Name)
+	switch name {
 	case "GET":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("GET expects 1 argument"))
-			return
+			return errorReply(fmt.Errorf("GET expects 1 argument"))
 		}
-		val := store.Get(cmd.Args[0])
-		writeBody(w, val)
+		return db.Get(cmd.Args[0])
 	case "SET":
 		if len(cmd.Args) != 2 {
-			respError(w, fmt.Errorf("SET expects 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("SET expects 2 arguments"))
 		}
-		store.Set(cmd.Args[0], cmd.Args[1])
-		writeBody(w, "OK")
-		return
+		return db.Set(cmd.Args[0], cmd.Args[1])
 	case "LLEN":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("LLEN expects 1 argument"))
-			return
+			return errorReply(fmt.Errorf("LLEN expects 1 argument"))
 		}
-		writeBody(w, store.Llen(cmd.Args[0]))
+		return db.Llen(cmd.Args[0])
 	case "RPUSH":
 		if len(cmd.Args) <= 1 {
-			respError(w, fmt.Errorf("RPUSH expects at least 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("RPUSH expects at least 2 arguments"))
 		}
-		writeBody(w, store.Rpush(cmd.Args[0], cmd.Args[1:]))
+		return db.Rpush(cmd.Args[0], cmd.Args[1:])
 	case "LPOP":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("LPOP expects 1 argument"))
-			return
+			return errorReply(fmt.Errorf("LPOP expects 1 argument"))
 		}
-		writeBody(w, store.Lpop(cmd.Args[0]))
+		return db.Lpop(cmd.Args[0])
 	case "RPOP":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("RPOP expects 1 argument"))
-			return
+			return errorReply(fmt.Errorf("RPOP expects 1 argument"))
 		}
-		writeBody(w, store.Rpop(cmd.Args[0]))
+		return db.Rpop(cmd.Args[0])
 	case "LRANGE":
 		if len(cmd.Args) != 3 {
-			respError(w, fmt.Errorf("LRANGE expects 3 arguments"))
-			return
+			return errorReply(fmt.Errorf("LRANGE expects 3 arguments"))
 		}
-		startIdx, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		startIdx, err := strconv.Atoi(cmd.Args[1])
 		if err != nil {
-			respError(w, fmt.Errorf("Error when parsing start"))
-			return
+			return errorReply(fmt.Errorf("Error when parsing start"))
 		}
-		endIdx, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+		endIdx, err := strconv.Atoi(cmd.Args[2])
 		if err != nil {
-			respError(w, fmt.Errorf("Error when parsing end"))
-			return
+			return errorReply(fmt.Errorf("Error when parsing end"))
 		}
-		writeBody(w, store.Lrange(cmd.Args[0], startIdx, endIdx))
+		return db.Lrange(cmd.Args[0], startIdx, endIdx)
 	case "SADD":
 		if len(cmd.Args) <= 1 {
-			respError(w, fmt.Errorf("SADD expects at least 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("SADD expects at least 2 arguments"))
 		}
-		writeBody(w, store.Sadd(cmd.Args[0], cmd.Args[1:]))
+		return db.Sadd(cmd.Args[0], cmd.Args[1:])
 	case "SCARD":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("SCARD expects 1 arguments"))
-			return
+			return errorReply(fmt.Errorf("SCARD expects 1 arguments"))
 		}
-		writeBody(w, store.Scard(cmd.Args[0]))
+		return db.Scard(cmd.Args[0])
 	case "SMEMBERS":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("SMEMBERS expects 1 arguments"))
-			return
+			return errorReply(fmt.Errorf("SMEMBERS expects 1 arguments"))
 		}
-		writeBody(w, store.Smembers(cmd.Args[0]))
+		return db.Smembers(cmd.Args[0])
 	case "SREM":
 		if len(cmd.Args) <= 1 {
-			respError(w, fmt.Errorf("SREM expects at least 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("SREM expects at least 2 arguments"))
 		}
-		writeBody(w, store.Srem(cmd.Args[0], cmd.Args[1:]))
+		return db.Srem(cmd.Args[0], cmd.Args[1:])
 	case "SINTER":
 		if len(cmd.Args) <= 1 {
-			respError(w, fmt.Errorf("SINTER expects at least 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("SINTER expects at least 2 arguments"))
 		}
-		writeBody(w, store.Sinter(cmd.Args))
+		return db.Sinter(cmd.Args)
+	case "SUNION", "SDIFF", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE", "SINTERCARD",
+		"SISMEMBER", "SMISMEMBER", "SRANDMEMBER", "SPOP", "SMOVE":
+		return setCommand(db, name, cmd)
 	case "KEYS":
-		writeBody(w, store.Keys())
-	case "DEL":
-		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("DEL expects 1 argument"))
-			return
+		if len(cmd.Args) > 1 {
+			return errorReply(fmt.Errorf("KEYS expects at most 1 argument"))
+		}
+		pattern := "*"
+		if len(cmd.Args) == 1 {
+			pattern = cmd.Args[0]
+		}
+		return db.Keys(pattern)
+	case "DEL", "UNLINK":
+		if len(cmd.Args) < 1 {
+			return errorReply(fmt.Errorf("%s expects at least 1 argument", name))
 		}
-		writeBody(w, store.Del(cmd.Args[0]))
+		return db.Del(cmd.Args)
 	case "FLUSHDB":
-		writeBody(w, store.Flushdb())
+		return db.Flushdb()
 	case "EXPIRE":
 		if len(cmd.Args) != 2 {
-			respError(w, fmt.Errorf("EXPIRE expects 2 arguments"))
-			return
+			return errorReply(fmt.Errorf("EXPIRE expects 2 arguments"))
 		}
 		second, err := strconv.ParseInt(cmd.Args[1], 10, 64)
 		if err != nil {
-			respError(w, fmt.Errorf("Error when parsing seconds"))
-			return
+			return errorReply(fmt.Errorf("Error when parsing seconds"))
 		}
 		if second <= 0 {
-			respError(w, fmt.Errorf("Second should be a positive number"))
-			return
+			return errorReply(fmt.Errorf("Second should be a positive number"))
 		}
-		writeBody(w, store.Expire(cmd.Args[0], second))
+		if second > math.MaxInt64/int64(time.Second) {
+			return errorReply(fmt.Errorf("invalid expire time"))
+		}
+		return db.Expire(cmd.Args[0], second)
 	case "TTL":
 		if len(cmd.Args) != 1 {
-			respError(w, fmt.Errorf("TTL expects 1 argument"))
-			return
+			return errorReply(fmt.Errorf("TTL expects 1 argument"))
 		}
-		writeBody(w, store.Ttl(cmd.Args[0]))
+		return db.Ttl(cmd.Args[0])
 	case "SAVE":
-		writeBody(w, store.Save())
+		return db.Save()
 	case "RESTORE":
-		writeBody(w, store.Restore())
+		return db.Restore()
+	case "PUBLISH", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PUBSUB":
+		return pubsubCommand(c, name, cmd)
+	case "LPUSH", "RPUSHX", "LPUSHX", "LINDEX", "LSET", "LINSERT", "LREM", "LTRIM", "LPOS", "LMOVE":
+		return listCommand(db, name, cmd)
+	case "BLPOP", "BRPOP", "BLMOVE":
+		return blockingCommand(db, c, name, cmd)
+	case "CONFIG":
+		return configCommand(db, cmd)
+	case "INFO":
+		return db.Info(cmd.Args)
+	case "MEMORY":
+		return memoryCommand(db, cmd)
+	case "MONITOR":
+		if len(cmd.Args) > 0 {
+			return errorReply(fmt.Errorf("MONITOR expects no argument"))
+		}
+		return errorReply(fmt.Errorf("MONITOR must be the only command of its request"))
+	case "SLOWLOG":
+		return slowlogCommand(db, cmd)
+	case "OBJECT":
+		return objectCommand(db, cmd)
+	case "TYPE", "EXISTS", "RENAME", "RENAMENX", "COPY", "RANDOMKEY", "DBSIZE", "TOUCH":
+		return keyspaceCommand(db, name, cmd)
+	case "SELECT", "MOVE", "SWAPDB", "FLUSHALL":
+		return dbCommand(db, c, name, cmd)
+	case "SCAN", "SSCAN", "HSCAN", "ZSCAN":
+		return scanCommand(db, name, cmd)
 	default:
-		respError(w, fmt.Errorf("unkonwn command: %s", cmd.Name))
+		return errorReply(fmt.Errorf("%s: %s", unknownCommandMsg, cmd.Name))
 	}
 }
 
+const unknownCommandMsg = "unkonwn command"
+
 type command struct {
 	Name string
 	Args []string
 }
 
-func writeBody(w http.ResponseWriter, body string) {
-	io.WriteString(w, body)
+// client holds the state of the connection commands are received from
+type client struct {
+	store *LedisStore
+	// ctx is done when the client goes away
+	ctx  context.Context
+	addr string
+	// subscriber is the id of the subscribe stream opened by the client
+	subscriber string
+	// db is the index of the selected database
+	db int
+	// blocked is set by a blocking command that has to wait for a list
+	blocked *listWaiter
+}
+
+// exec runs cmd, on the executor in serial mode, then waits for the list a
+// blocking command blocked on, if any
+func (c *client) exec(cmd *command) reply {
+	atomic.AddInt64(&c.store.stats.commands, 1)
+	var rep reply
+	c.store.execute(func() {
+		defer func() {
+			// a bug in a command fails the command, not the request or the
+			// executor
+			if err := recover(); err != nil {
+				rep = errorReply(fmt.Errorf("internal error: %v", err))
+			}
+		}()
+		c.store.monitors.feed(c, cmd)
+		start := time.Now()
+		rep = execCommand(c, cmd)
+		elapsed := time.Since(start)
+		c.store.metrics.commandDone(cmd, rep, elapsed)
+		c.store.logSlow(c, cmd, elapsed)
+	})
+	if waiter := c.blocked; waiter != nil {
+		c.blocked = nil
+		return waiter.db.waitBlocked(c.ctx, waiter)
+	}
+	return rep
 }
 
-func respError(w http.ResponseWriter, err error) {
-	writeBody(w, fmt.Sprintf("ERROR: %s", err.Error()))
+func writeBody(w http.ResponseWriter, body string) {
+	io.WriteString(w, body)
 }
 
 func parseCommand(body string) (*command, error) {
@@ -221,6 +336,10 @@ func parseCommand(body string) (*command, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newCommand(args)
+}
+
+func newCommand(args []string) (*command, error) {
 	if len(args) < 1 {
 		return nil, fmt.Errorf("empty command")
 	}
@@ -228,412 +347,236 @@ func parseCommand(body string) (*command, error) {
 	return &command{Name: args[0], Args: args[1:]}, nil
 }
 
-func setHTTPStatus(w http.ResponseWriter) {
-	w.Header().Add("Access-Control-Allow-Origin", `*`)
-	w.Header().Add("Access-Control-Allow-Methods", `GET, POST, PUT, DELETE, OPTIONS`)
-}
-
-func (store *LedisStore) Get(key string) string {
-	store.lock.RLock()
-	defer store.lock.RUnlock()
-
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-	if storeVal.DataType != TypeString {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	return *storeVal.StringData
-}
-
-func (store *LedisStore) Set(key string, val string) {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	// set always success, it even overwrite other data types
-	store.Data[key] = LedisData{
-		DataType:   TypeString,
-		SetData:    nil,
-		ListData:   nil,
-		StringData: &val}
-	// remove the expire if exist
-	delete(store.ExpireTime, key)
-}
+type replyFormat int
 
-func (store *LedisStore) Llen(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+const (
+	// formatText replies to a single text command with plain text
+	formatText replyFormat = iota
+	// formatJSON replies to pipelined commands with a JSON array of the
+	// plain text results
+	formatJSON
+	// formatRESP replies to RESP encoded commands with RESP replies
+	formatRESP
+)
 
-	if store.Data[key].DataType != TypeList {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
+// parseRequest extracts the commands carried by a request body. The body is
+// either RESP encoded, a JSON array of argv arrays, or text holding one
+// command per line. A text request carrying more than one line of commands
+// is pipelined and gets a JSON array of results, like a JSON request.
+func parseRequest(contentType string, body []byte) ([]*command, replyFormat, error) {
+	if strings.HasPrefix(contentType, respContentType) {
+		cmds, err := parseRESPCommands(body)
+		return cmds, formatRESP, err
 	}
 
-	return fmt.Sprintf("%d", len(*store.Data[key].ListData))
-}
-
-func (store *LedisStore) Rpush(key string, values []string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	storeVal, ok := store.Data[key]
-	if ok {
-		if storeVal.DataType != TypeList {
-			return "WRONGTYPE Operation against a key holding the wrong kind of value"
-		}
-
-		// append value
-		for _, val := range values {
-			*store.Data[key].ListData = append(*store.Data[key].ListData, val)
+	if strings.HasPrefix(contentType, "application/json") {
+		var argvs [][]string
+		if err := json.Unmarshal(body, &argvs); err != nil {
+			return nil, formatText, fmt.Errorf("invalid JSON pipeline: %s", err.Error())
 		}
-		return fmt.Sprintf("%d", len(*storeVal.ListData))
-	}
-
-	// create the list
-	store.Data[key] = LedisData{
-		DataType:   TypeList,
-		SetData:    nil,
-		ListData:   &values,
-		StringData: nil}
-	return fmt.Sprintf("%d", len(values))
-}
-
-func (store *LedisStore) Lpop(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	// check if key is exist
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-
-	if storeVal.DataType != TypeList {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	// else, lpop
-	if len(*storeVal.ListData) == 0 {
-		return "(nil)"
-	}
-	retVal := (*storeVal.ListData)[0]
-	*storeVal.ListData = append((*storeVal.ListData)[:0], (*storeVal.ListData)[1:]...)
-	return retVal
-}
-
-func (store *LedisStore) Rpop(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	// check if key is exist
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-
-	// if key is not list, return wrong type
-	if storeVal.DataType != TypeList {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	// else, rpop
-	if len(*storeVal.ListData) == 0 {
-		return "(nil)"
-	}
-	lastIdx := len(*storeVal.ListData) - 1
-	retVal := (*storeVal.ListData)[lastIdx]
-	*storeVal.ListData = append((*storeVal.ListData)[:lastIdx], (*storeVal.ListData)[lastIdx+1:]...)
-	return retVal
-}
-
-func (store *LedisStore) Lrange(key string, start, stop uint64) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	// check if key is exist
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-
-	// if key is not list, return wrong type
-	if storeVal.DataType != TypeList {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	lenListData := uint64(len(*storeVal.ListData))
-	if lenListData == 0 {
-		return "(nil)"
-	}
-
-	stopIdx := stop
-	if stopIdx >= lenListData {
-		stopIdx = lenListData
-	}
-
-	retStr := ""
-	for i := start; i < stopIdx; i++ {
-		retStr += (*storeVal.ListData)[i] + "\r\n"
-	}
-	return retStr
-}
-
-func (store *LedisStore) Sadd(key string, values []string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	count := 0
-	storeVal, ok := store.Data[key]
-	if ok {
-		if storeVal.DataType != TypeSet {
-			return "WRONGTYPE Operation against a key holding the wrong kind of value"
+		if len(argvs) == 0 {
+			return nil, formatText, fmt.Errorf("empty command")
 		}
-
-		// add item to set
-		setVals := *storeVal.SetData
-		for _, val := range values {
-			if _, ok = setVals[val]; !ok {
-				count++
-				setVals[val] = true
+		cmds := make([]*command, 0, len(argvs))
+		for _, argv := range argvs {
+			cmd, err := newCommand(argv)
+			if err != nil {
+				return nil, formatText, err
 			}
+			cmds = append(cmds, cmd)
 		}
-
-		storeVal.SetData = &setVals
-		return fmt.Sprintf("%d", count)
+		return cmds, formatJSON, nil
 	}
 
-	// not exist, create set
-	setVals := make(map[string]bool)
-	for _, val := range values {
-		if _, ok := setVals[val]; !ok {
-			count++
+	lines := splitCommandLines(string(body))
+	if len(lines) <= 1 {
+		// a single command keeps the plain text reply
+		cmd, err := parseCommand(string(body))
+		if err != nil {
+			return nil, formatText, err
 		}
-		setVals[val] = true
-	}
-	store.Data[key] = LedisData{
-		DataType:   TypeSet,
-		SetData:    &setVals,
-		ListData:   nil,
-		StringData: nil}
-	return fmt.Sprintf("%d", len(values))
-}
-
-func (store *LedisStore) Scard(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	count := 0
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-	if storeVal.DataType != TypeSet {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	for _ = range *storeVal.SetData {
-		count++
-	}
-	return fmt.Sprintf("%d", count)
-}
-
-func (store *LedisStore) Smembers(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	resStr := ""
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-	if storeVal.DataType != TypeSet {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
+		return []*command{cmd}, formatText, nil
 	}
 
-	if len(*storeVal.SetData) == 0 {
-		return "(empty set)"
-	}
-
-	for key := range *storeVal.SetData {
-		resStr += key + "\r\n"
-	}
-	return resStr
-}
-
-func (store *LedisStore) Srem(key string, values []string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	count := 0
-	storeVal, ok := store.Data[key]
-	if !ok {
-		return "key not found"
-	}
-	if storeVal.DataType != TypeSet {
-		return "WRONGTYPE Operation against a key holding the wrong kind of value"
-	}
-
-	if len(*storeVal.SetData) == 0 {
-		return "0"
-	}
-
-	for _, val := range values {
-		if _, ok := (*storeVal.SetData)[val]; ok {
-			count++
-			delete(*storeVal.SetData, val)
+	cmds := make([]*command, 0, len(lines))
+	for _, line := range lines {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			return nil, formatText, err
 		}
+		cmds = append(cmds, cmd)
 	}
-
-	return fmt.Sprintf("%d", count)
+	return cmds, formatJSON, nil
 }
 
-func (store *LedisStore) Sinter(keys []string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	firstSet := *store.Data[keys[0]].SetData
-	for key := range firstSet {
-		firstSet[key] = true
-	}
-	for _, key := range keys {
-		storeVal, ok := store.Data[key]
-		if !ok {
-			return fmt.Sprintf("key not found: %s", key)
+// splitCommandLines splits a text body into command lines, skipping blank
+// lines. A quoted argument may span several lines, so lines are joined back
+// until the quoting is balanced.
+func splitCommandLines(body string) []string {
+	lines := []string{}
+	pending := ""
+	for _, line := range strings.Split(body, "\n") {
+		if pending != "" {
+			line = pending + "\n" + line
 		}
-		if storeVal.DataType != TypeSet {
-			return fmt.Sprintf("WRONGTYPE Operation against a key: %s holding the wrong kind of value", key)
+		_, err := shellquote.Split(line)
+		if err == shellquote.UnterminatedSingleQuoteError ||
+			err == shellquote.UnterminatedDoubleQuoteError ||
+			err == shellquote.UnterminatedEscapeError {
+			pending = line
+			continue
 		}
-
-		for setVal := range firstSet {
-			if _, ok := (*storeVal.SetData)[setVal]; !ok {
-				firstSet[setVal] = false
-			}
+		pending = ""
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
 	}
-
-	resStr := ""
-	for key := range firstSet {
-		if firstSet[key] == true {
-			resStr += key + "\r\n"
-		}
-	}
-
-	if resStr == "" {
-		return "empty"
+	if pending != "" {
+		lines = append(lines, pending)
 	}
-	return resStr
+	return lines
 }
 
-func (store *LedisStore) Keys() string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+func setHTTPStatus(w http.ResponseWriter) {
+	w.Header().Add("Access-Control-Allow-Origin", `*`)
+	w.Header().Add("Access-Control-Allow-Methods", `GET, POST, PUT, DELETE, OPTIONS`)
+}
 
-	valRet := ""
-	for key := range store.Data {
-		valRet += key + "\r\n"
+func (db *ledisDB) Get(key string) reply {
+	val, ok, err := db.api().Get(key)
+	if err != nil {
+		return apiErrorReply(err, reply{})
 	}
-
-	if valRet == "" {
-		return "empty"
+	if !ok {
+		return nilReply("key not found")
 	}
-	return valRet
+	return bulkReply(string(val))
 }
 
-func (store *LedisStore) Del(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	if _, ok := store.Data[key]; !ok {
-		return "key not found"
+func (db *ledisDB) Set(key string, val string) reply {
+	if err := db.api().Set(key, []byte(val)); err != nil {
+		return apiErrorReply(err, reply{})
 	}
+	return statusReply("OK")
+}
 
-	delete(store.Data, key)
-	return "1"
+// Keys returns the keys matching the glob style pattern
+func (db *ledisDB) Keys(pattern string) reply {
+	return bulkArrayReply(db.api().Keys(pattern)).whenEmpty("empty")
 }
 
-func (store *LedisStore) Flushdb() string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-	for key := range store.Data {
-		delete(store.Data, key)
+// Del deletes keys, returning how many existed
+func (db *ledisDB) Del(keys []string) reply {
+	count := db.api().Del(keys...)
+	if count == 0 {
+		return intReply(0).withText("key not found")
 	}
-	return "OK"
+	return intReply(count)
 }
 
-func (store *LedisStore) Expire(key string, second int64) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+func (db *ledisDB) Flushdb() reply {
+	unlock := lockAll(db)
+	defer unlock()
+	db.flush()
+	return statusReply("OK")
+}
 
-	if _, ok := store.Data[key]; !ok {
-		return "key not found"
+func (db *ledisDB) Expire(key string, second int64) reply {
+	if err := db.api().Expire(key, time.Duration(second)*time.Second); err != nil {
+		return apiErrorReply(err, intReply(0).withText("key not found"))
 	}
-
-	store.ExpireTime[key] = time.Now().Unix() + second
-	return fmt.Sprintf("%d", second)
+	return intReply(1).withText(fmt.Sprintf("%d", second))
 }
 
-func (store *LedisStore) Ttl(key string) string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
-
-	if _, ok := store.Data[key]; !ok {
-		return "key not found"
+func (db *ledisDB) Ttl(key string) reply {
+	ttl, ok, err := db.api().TTL(key)
+	if err != nil {
+		return apiErrorReply(err, intReply(-2).withText("key not found"))
 	}
-	if _, ok := store.ExpireTime[key]; !ok {
-		return "-1"
+	if !ok {
+		return intReply(-1)
 	}
-
-	return fmt.Sprintf("%d", store.ExpireTime[key]-time.Now().Unix())
+	return intReply(int(ttl / time.Second))
 }
 
-func (store *LedisStore) Save() string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// Save writes all the databases to the snapshot file
+func (db *ledisDB) Save() reply {
+	unlock := rlockAll(db.dbs...)
+	defer unlock()
+	start := time.Now()
+	defer func() {
+		db.metrics.saves.observe(time.Since(start))
+	}()
 	encodeFile, err := os.Create("accounts.gob")
 	if err != nil {
-		return err.Error()
+		return errorReply(err).withText(err.Error())
 	}
+	defer encodeFile.Close()
 
 	e := gob.NewEncoder(encodeFile)
 
-	err = e.Encode(store)
+	dirty := atomic.LoadInt64(&db.stats.dirty)
+	err = e.Encode(db.snapshot())
 	if err != nil {
-		return err.Error()
+		atomic.StoreInt32(&db.stats.lastSaveFailed, 1)
+		return errorReply(err).withText(err.Error())
 	}
 
-	return "OK"
+	if info, err := encodeFile.Stat(); err == nil {
+		atomic.StoreInt64(&db.metrics.snapshotSize, info.Size())
+	}
+	atomic.AddInt64(&db.stats.dirty, -dirty)
+	atomic.StoreInt64(&db.stats.lastSave, time.Now().Unix())
+	atomic.StoreInt32(&db.stats.lastSaveFailed, 0)
+	atomic.AddInt64(&db.stats.saves, 1)
+	return statusReply("OK")
 }
 
-func (store *LedisStore) Restore() string {
-	store.lock.Lock()
-	defer store.lock.Unlock()
+// Restore loads the databases from the snapshot file, its keys overwrite the
+// existing ones
+func (db *ledisDB) Restore() reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := lockAll(db.dbs...)
+	defer unlock()
+	start := time.Now()
+	defer func() {
+		db.metrics.restores.observe(time.Since(start))
+	}()
 
 	// Open a RO file
 	decodeFile, err := os.Open("accounts.gob")
 	if err != nil {
-		return err.Error()
+		return errorReply(err).withText(err.Error())
 	}
 	defer decodeFile.Close()
 
-	var decodedMap LedisStore
+	var decodedMap snapshotStore
 	d := gob.NewDecoder(decodeFile)
 
 	// Decoding the serialized data
 	err = d.Decode(&decodedMap)
 	if err != nil {
-		return err.Error()
+		return errorReply(err).withText(err.Error())
 	}
 
-	// restore all keys in the decodedMap
-	for key, val := range decodedMap.Data {
-		delete(store.Data, key)
-		store.Data[key] = val
+	if info, err := decodeFile.Stat(); err == nil {
+		atomic.StoreInt64(&db.metrics.snapshotSize, info.Size())
 	}
-	for key, val := range decodedMap.ExpireTime {
-		delete(store.ExpireTime, key)
-		store.ExpireTime[key] = val
+
+	for index := range decodedMap.Databases {
+		if index <= 0 || index >= len(db.dbs) {
+			err := fmt.Errorf("snapshot has database %d, only %d databases are configured", index, len(db.dbs))
+			return errorReply(err).withText(err.Error())
+		}
 	}
 
-	return "OK"
+	// restore all keys in the decodedMap
+	db.dbs[0].restore(snapshotDB{Data: decodedMap.Data, ExpireTime: decodedMap.ExpireTime})
+	for index, snap := range decodedMap.Databases {
+		db.dbs[index].restore(snap)
+	}
+	return statusReply("OK")
 }
@@ -1,7 +1,12 @@
 package handlers_test
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +26,31 @@ func SendCommand(cmd string) string {
 	return body
 }
 
+// SendRESP sends RESP encoded commands and returns the raw RESP replies
+func SendRESP(cmds ...[]string) string {
+	var buf bytes.Buffer
+	for _, args := range cmds {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	return SendRawRESP(buf.String())
+}
+
+func SendRawRESP(body string) string {
+	resp, err := http.Post(serverUrl, "application/x-resp", bytes.NewBufferString(body))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	return string(reply)
+}
+
 type ValidateExactTest struct {
 	command  string
 	expect   string
@@ -34,10 +64,10 @@ type ValidateContainTest struct {
 }
 
 func TestExpire(t *testing.T) {
-	handlers.InitStore()
-	handler := &handlers.LedisHandler{}
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
 	server := httptest.NewServer(handler)
-	go handlers.ExpiredCleaner()
 	defer server.Close()
 	serverUrl = server.URL
 	g := NewGomegaWithT(t)
@@ -59,8 +89,9 @@ func TestExpire(t *testing.T) {
 }
 
 func TestLedisOps(t *testing.T) {
-	handlers.InitStore()
-	handler := &handlers.LedisHandler{}
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
 	server := httptest.NewServer(handler)
 	defer server.Close()
 	serverUrl = server.URL
@@ -90,9 +121,10 @@ func TestLedisOps(t *testing.T) {
 		{`RPOP testlist`, "4", ""},
 		{`LPOP testlist`, "2", ""},
 		{`LPOP testlist`, "3", ""},
-		{`LPOP testlist`, "(nil)", ""},
-		{`RPOP testlist`, "(nil)", ""},
-		{`LRANGE testlist 1 2`, "(nil)", ""},
+		{`LPOP testlist`, "key not found", "Empty list is deleted"},
+		{`RPOP testlist`, "key not found", ""},
+		{`LRANGE testlist 1 2`, "key not found", ""},
+		{`RPUSH testlist 1`, "1", "Recreate the deleted list"},
 		{`SADD testset 1 2 3`, "3", "Test SADD"},
 		{`SADD testkey 1 2 3`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
 		{`SCARD testset`, "3", "Test SCARD"},
@@ -155,8 +187,9 @@ func TestLedisOps(t *testing.T) {
 }
 
 func TestInvalidCommand(t *testing.T) {
-	handlers.InitStore()
-	handler := &handlers.LedisHandler{}
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
 	server := httptest.NewServer(handler)
 	defer server.Close()
 	serverUrl = server.URL
@@ -175,14 +208,14 @@ func TestInvalidCommand(t *testing.T) {
 		{"LPOP", "LPOP expects 1 argument"},
 		{"RPOP", "RPOP expects 1 argument"},
 		{"LRANGE", "LRANGE expects 3 arguments"},
-		{"LRANGE somekey -1 1", "Error when parsing start"},
-		{"LRANGE somekey 1 -1", "Error when parsing end"},
+		{"LRANGE somekey a 1", "Error when parsing start"},
+		{"LRANGE somekey 1 1.5", "Error when parsing end"},
 		{"SADD somekey", "SADD expects at least 2 arguments"},
 		{"SCARD", "SCARD expects 1 arguments"},
 		{"SMEMBERS", "SMEMBERS expects 1 arguments"},
 		{"SREM somekey", "SREM expects at least 2 arguments"},
 		{"SINTER somekey", "SINTER expects at least 2 arguments"},
-		{"DEL", "DEL expects 1 argument"},
+		{"DEL", "DEL expects at least 1 argument"},
 		{"EXPIRE", "EXPIRE expects 2 arguments"},
 		{"EXPIRE somekey abc", "Error when parsing seconds"},
 		{"EXPIRE somekey -1", "Second should be a positive number"},
@@ -195,3 +228,116 @@ func TestInvalidCommand(t *testing.T) {
 		g.Expect(body).To(ContainSubstring(test.errMsg))
 	}
 }
+
+func TestPipeline(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	body := SendCommand("SET testkey 123\nRPUSH testlist a b c\n\nGET testkey\nLLEN testlist\nGET")
+	g.Expect(body).To(MatchJSON(`["OK", "3", "123", "3", "ERROR: GET expects 1 argument"]`), "Test text pipeline")
+
+	body = SendCommand("SET testkey \"multi\nline\"\r\nGET testkey\r\n")
+	g.Expect(body).To(MatchJSON(`["OK", "multi\nline"]`), "Quoted value may span lines")
+
+	_, body, errs := gorequest.New().Post(serverUrl).Type("json").
+		SendString(`[["SADD", "testset", "x y", "z"], ["SCARD", "testset"], ["LPOP", "testlist"]]`).End()
+	g.Expect(errs).To(BeNil())
+	g.Expect(body).To(MatchJSON(`["2", "2", "a"]`), "Test JSON pipeline")
+
+	_, body, _ = gorequest.New().Post(serverUrl).Type("json").SendString(`[["GET", "testkey"], []]`).End()
+	g.Expect(body).To(Equal("ERROR: empty command"))
+	_, body, _ = gorequest.New().Post(serverUrl).Type("json").SendString(`{"GET": "testkey"}`).End()
+	g.Expect(body).To(ContainSubstring("ERROR: invalid JSON pipeline"))
+
+	body = SendCommand(`GET testkey`)
+	g.Expect(body).To(Equal("multi\nline"), "Single command keeps plain reply")
+}
+
+func TestBinarySafeRESP(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	blob := "\x00\xff\xfe\r\n\"'quoted' \\ blob\x00"
+	body := SendRESP(
+		[]string{"SET", "bin\x00key", blob},
+		[]string{"GET", "bin\x00key"},
+		[]string{"GET", "no-exist"},
+		[]string{"RPUSH", "binlist", blob, "a\r\nb"},
+		[]string{"LRANGE", "binlist", "0", "10"},
+		[]string{"LPOP", "binlist"},
+		[]string{"SADD", "binset", blob},
+		[]string{"SMEMBERS", "binset"},
+		[]string{"LLEN", "bin\x00key"},
+		[]string{"SCARD", "no-exist"},
+	)
+	g.Expect(body).To(Equal("+OK\r\n" +
+		fmt.Sprintf("$%d\r\n%s\r\n", len(blob), blob) +
+		"$-1\r\n" +
+		":2\r\n" +
+		fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$4\r\na\r\nb\r\n", len(blob), blob) +
+		fmt.Sprintf("$%d\r\n%s\r\n", len(blob), blob) +
+		":1\r\n" +
+		fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(blob), blob) +
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n" +
+		":0\r\n"))
+
+	body = SendRESP([]string{"GET"}, []string{"some-invalid-command"})
+	g.Expect(body).To(Equal("-ERR GET expects 1 argument\r\n-ERR unkonwn command: some-invalid-command\r\n"))
+
+	body = SendRawRESP("*1\r\n$4\r\nGET\r\n")
+	g.Expect(body).To(HavePrefix("-ERR Protocol error"), "Bulk length mismatch")
+	body = SendRawRESP("*1000000000000\r\n")
+	g.Expect(body).To(HavePrefix("-ERR Protocol error"), "Array count larger than the request")
+	body = SendRawRESP("*1\r\n$9223372036854775807\r\n")
+	g.Expect(body).To(HavePrefix("-ERR Protocol error"), "Bulk length larger than the request")
+	body = SendRawRESP("GET testkey\r\n")
+	g.Expect(body).To(HavePrefix("-ERR Protocol error"), "Inline commands are not RESP")
+	body = SendRawRESP("")
+	g.Expect(body).To(Equal("-ERR empty command\r\n"))
+}
+
+func TestKeysPattern(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	SendCommand("SET session:1 a\nSET session:22 b\nSET user:a c\nSET user:b d\nSET user:c e\nSET star*key f\nSET hello g\nSET hallo h\nSET hxllo i")
+
+	tests := []ValidateContainTest{
+		{`KEYS session:*`, []string{"session:1", "session:22"}, "Test KEYS pattern"},
+		{`KEYS session:?`, []string{"session:1"}, "? matches one byte"},
+		{`KEYS h[ae]llo`, []string{"hello", "hallo"}, "Brackets match one of the bytes"},
+		{`KEYS h[^e]llo`, []string{"hallo", "hxllo"}, "^ negates the brackets"},
+		{`KEYS user:[a-b]`, []string{"user:a", "user:b"}, "Ranges in brackets"},
+		{`KEYS star\*key`, []string{"star*key"}, "Backslash escapes"},
+		{`KEYS *l*o`, []string{"hello", "hallo", "hxllo"}, ""},
+		{`KEYS`, []string{"session:1", "session:22", "user:a", "user:b", "user:c", "star*key", "hello", "hallo", "hxllo"}, "No pattern returns every key"},
+	}
+	for _, test := range tests {
+		lines := strings.Split(strings.TrimSuffix(SendCommand(test.command), "\r\n"), "\r\n")
+		g.Expect(lines).To(ConsistOf(test.expects), test.testName+": "+test.command)
+	}
+
+	g.Expect(SendCommand(`KEYS nothing*`)).To(Equal("empty"))
+	g.Expect(SendCommand(`KEYS a b`)).To(Equal("ERROR: KEYS expects at most 1 argument"))
+
+	// many stars do not make matching exponential
+	SendCommand(`SET ` + strings.Repeat("a", 100) + ` x`)
+	start := time.Now()
+	g.Expect(SendCommand(`KEYS ` + strings.Repeat("a*", 30) + `b`)).To(Equal("empty"))
+	g.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+}
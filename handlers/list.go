@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func listCommand(db *ledisDB, name string, cmd *command) reply {
+	switch name {
+	case "LPUSH", "RPUSHX", "LPUSHX":
+		if len(cmd.Args) <= 1 {
+			return errorReply(fmt.Errorf("%s expects at least 2 arguments", name))
+		}
+		switch name {
+		case "LPUSH":
+			return db.Lpush(cmd.Args[0], cmd.Args[1:])
+		case "RPUSHX":
+			return db.Rpushx(cmd.Args[0], cmd.Args[1:])
+		default:
+			return db.Lpushx(cmd.Args[0], cmd.Args[1:])
+		}
+	case "LINDEX":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("LINDEX expects 2 arguments"))
+		}
+		index, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing index"))
+		}
+		return db.Lindex(cmd.Args[0], index)
+	case "LSET":
+		if len(cmd.Args) != 3 {
+			return errorReply(fmt.Errorf("LSET expects 3 arguments"))
+		}
+		index, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing index"))
+		}
+		return db.Lset(cmd.Args[0], index, cmd.Args[2])
+	case "LINSERT":
+		if len(cmd.Args) != 4 {
+			return errorReply(fmt.Errorf("LINSERT expects 4 arguments"))
+		}
+		where := strings.ToUpper(cmd.Args[1])
+		if where != "BEFORE" && where != "AFTER" {
+			return errorReply(fmt.Errorf("Error when parsing position, expects BEFORE or AFTER"))
+		}
+		return db.Linsert(cmd.Args[0], where == "BEFORE", cmd.Args[2], cmd.Args[3])
+	case "LREM":
+		if len(cmd.Args) != 3 {
+			return errorReply(fmt.Errorf("LREM expects 3 arguments"))
+		}
+		count, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing count"))
+		}
+		return db.Lrem(cmd.Args[0], count, cmd.Args[2])
+	case "LTRIM":
+		if len(cmd.Args) != 3 {
+			return errorReply(fmt.Errorf("LTRIM expects 3 arguments"))
+		}
+		start, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing start"))
+		}
+		stop, err := strconv.Atoi(cmd.Args[2])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing stop"))
+		}
+		return db.Ltrim(cmd.Args[0], start, stop)
+	case "LPOS":
+		return lposCommand(db, cmd)
+	default:
+		if len(cmd.Args) != 4 {
+			return errorReply(fmt.Errorf("LMOVE expects 4 arguments"))
+		}
+		popLeft, err := parseListSide(cmd.Args[2])
+		if err != nil {
+			return errorReply(err)
+		}
+		pushLeft, err := parseListSide(cmd.Args[3])
+		if err != nil {
+			return errorReply(err)
+		}
+		return db.Lmove(cmd.Args[0], cmd.Args[1], popLeft, pushLeft)
+	}
+}
+
+// lposCommand parses LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lposCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 2 || len(cmd.Args)%2 != 0 {
+		return errorReply(fmt.Errorf("LPOS expects a key, an element and option value pairs"))
+	}
+
+	rank, count, maxLen := 1, -1, 0
+	for i := 2; i < len(cmd.Args); i += 2 {
+		option := strings.ToUpper(cmd.Args[i])
+		val, err := strconv.Atoi(cmd.Args[i+1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing %s", option))
+		}
+		switch option {
+		case "RANK":
+			if val == 0 {
+				return errorReply(fmt.Errorf("RANK can't be zero: use 1 to start from the first match, -1 from the last match"))
+			}
+			rank = val
+		case "COUNT":
+			if val < 0 {
+				return errorReply(fmt.Errorf("COUNT can't be negative"))
+			}
+			count = val
+		case "MAXLEN":
+			if val < 0 {
+				return errorReply(fmt.Errorf("MAXLEN can't be negative"))
+			}
+			maxLen = val
+		default:
+			return errorReply(fmt.Errorf("unknown LPOS option: %s", cmd.Args[i]))
+		}
+	}
+	return db.Lpos(cmd.Args[0], cmd.Args[1], rank, count, maxLen)
+}
+
+func (db *ledisDB) Llen(key string) reply {
+	length, err := db.api().LLen(key)
+	if err != nil {
+		return apiErrorReply(err, intReply(0).withText("key not found"))
+	}
+	return intReply(length)
+}
+
+func (db *ledisDB) Rpush(key string, values []string) reply {
+	return pushReply(db.api().RPush(key, toBytes(values)...))
+}
+
+func (db *ledisDB) Lpush(key string, values []string) reply {
+	return pushReply(db.api().LPush(key, toBytes(values)...))
+}
+
+// Rpushx appends values only if key already holds a list
+func (db *ledisDB) Rpushx(key string, values []string) reply {
+	return pushReply(db.api().RPushX(key, toBytes(values)...))
+}
+
+// Lpushx prepends values only if key already holds a list
+func (db *ledisDB) Lpushx(key string, values []string) reply {
+	return pushReply(db.api().LPushX(key, toBytes(values)...))
+}
+
+func pushReply(length int, err error) reply {
+	if err != nil {
+		return apiErrorReply(err, reply{})
+	}
+	return intReply(length)
+}
+
+func (db *ledisDB) Lpop(key string) reply {
+	return popReply(db.api().LPop(key))
+}
+
+func (db *ledisDB) Rpop(key string) reply {
+	return popReply(db.api().RPop(key))
+}
+
+func popReply(val []byte, err error) reply {
+	if err != nil {
+		return apiErrorReply(err, nilReply("key not found"))
+	}
+	return bulkReply(string(val))
+}
+
+// pushValues adds values to the head (left) or the tail of the list at key,
+// creating the list if needed, and marks key ready for the clients blocked
+// on it. It returns the length of the list before handing elements out to
+// them. The key must not hold another type, and its shard lock must be held.
+func (db *ledisDB) pushValues(key string, values []string, left bool) int {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		// create the list
+		storeVal = LedisData{
+			DataType:   TypeList,
+			SetData:    nil,
+			ListData:   newLedisList(nil),
+			StringData: nil}
+		db.put(key, storeVal)
+	}
+
+	event := "rpush"
+	list := storeVal.ListData
+	limits := db.limits()
+	if left {
+		// the values are pushed one after another, so the last one ends
+		// up at the head of the list
+		event = "lpush"
+		for _, val := range values {
+			list.PushFront(val)
+			limits.fitList(list)
+		}
+	} else {
+		for _, val := range values {
+			list.PushBack(val)
+			limits.fitList(list)
+		}
+	}
+	db.resized(key)
+	db.notify(notifyList, event, key)
+
+	db.signalReady(key)
+	return storeVal.ListData.Len()
+}
+
+// popValue removes and returns the head (left) or the tail element of the
+// non empty list at key. The caller deletes the list with deleteIfEmpty once
+// done with it. The shard lock of key must be held.
+func (db *ledisDB) popValue(key string, left bool) string {
+	storeVal, _ := db.lookup(key)
+	listData := storeVal.ListData
+
+	if left {
+		retVal := listData.PopFront()
+		db.resized(key)
+		db.notify(notifyList, "lpop", key)
+		return retVal
+	}
+
+	retVal := listData.PopBack()
+	db.resized(key)
+	db.notify(notifyList, "rpop", key)
+	return retVal
+}
+
+func (db *ledisDB) Lrange(key string, start, stop int) reply {
+	vals, err := db.api().LRange(key, start, stop)
+	if err != nil {
+		return apiErrorReply(err, arrayReply(nil).withText("key not found"))
+	}
+	return bulkArrayReply(toStrings(vals))
+}
+
+// deleteIfEmpty removes the collection at key once its last element is gone,
+// Redis never keeps empty lists or sets. The shard lock of key must be held.
+func (db *ledisDB) deleteIfEmpty(key string) {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return
+	}
+	if (storeVal.DataType == TypeList && storeVal.ListData.Len() == 0) ||
+		(storeVal.DataType == TypeSet && storeVal.SetData.Len() == 0) {
+		db.remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+// listIndex converts a Redis style index, negative ones counting from the
+// tail, to an offset in a list of length n. It reports false when the index
+// is out of range.
+func listIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
+
+// listRange converts Redis style inclusive start and stop indexes, negative
+// ones counting from the tail, to the [from, to) bounds of the range in a
+// list of length n. Out of range indexes are clamped.
+func listRange(start, stop, n int) (int, int) {
+	if start < 0 {
+		start += n
+		if start < 0 {
+			start = 0
+		}
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// getList returns the list at key, a nil list when the key does not exist,
+// or a WRONGTYPE error reply. The shard lock of key must be held.
+func (db *ledisDB) getList(key string) (*ledisList, *reply) {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if storeVal.DataType != TypeList {
+		rep := wrongTypeReply()
+		return nil, &rep
+	}
+	return storeVal.ListData, nil
+}
+
+func (db *ledisDB) Lindex(key string, index int) reply {
+	val, ok, err := db.api().LIndex(key, index)
+	if err != nil {
+		return apiErrorReply(err, nilReply("key not found"))
+	}
+	if !ok {
+		return nilReply("")
+	}
+	return bulkReply(string(val))
+}
+
+func (db *ledisDB) Lset(key string, index int, val string) reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	listData, errRep := db.getList(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if listData == nil {
+		return errorReply(fmt.Errorf("no such key"))
+	}
+
+	offset, ok := listIndex(index, listData.Len())
+	if !ok {
+		return errorReply(fmt.Errorf("index out of range"))
+	}
+	listData.Set(offset, val)
+	db.resized(key)
+	db.notify(notifyList, "lset", key)
+	return statusReply("OK")
+}
+
+// Linsert inserts val before or after the first occurrence of pivot, it
+// returns the new length of the list or -1 when pivot is not found
+func (db *ledisDB) Linsert(key string, before bool, pivot, val string) reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	listData, errRep := db.getList(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if listData == nil {
+		return intReply(0).withText("key not found")
+	}
+
+	for i := 0; i < listData.Len(); i++ {
+		if listData.Index(i) != pivot {
+			continue
+		}
+		if !before {
+			i++
+		}
+		listData.Insert(i, val)
+		db.resized(key)
+		db.notify(notifyList, "linsert", key)
+		return intReply(listData.Len())
+	}
+	return intReply(-1)
+}
+
+// Lrem removes the first count occurrences of val when count is positive,
+// the last -count ones when it is negative, and all of them when it is 0
+func (db *ledisDB) Lrem(key string, count int, val string) reply {
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	listData, errRep := db.getList(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if listData == nil {
+		return intReply(0).withText("key not found")
+	}
+
+	list := listData.Values()
+	removed := 0
+	keep := make([]string, 0, len(list))
+	if count >= 0 {
+		for _, elem := range list {
+			if elem == val && (count == 0 || removed < count) {
+				removed++
+				continue
+			}
+			keep = append(keep, elem)
+		}
+	} else {
+		// walk from the tail, the kept elements are reversed back after
+		for i := len(list) - 1; i >= 0; i-- {
+			if list[i] == val && removed < -count {
+				removed++
+				continue
+			}
+			keep = append(keep, list[i])
+		}
+		for i, j := 0, len(keep)-1; i < j; i, j = i+1, j-1 {
+			keep[i], keep[j] = keep[j], keep[i]
+		}
+	}
+
+	if removed > 0 {
+		*listData = *newLedisList(keep)
+		db.resized(key)
+		db.notify(notifyList, "lrem", key)
+		db.deleteIfEmpty(key)
+	}
+	return intReply(removed)
+}
+
+// Ltrim keeps only the elements between the inclusive start and stop indexes
+func (db *ledisDB) Ltrim(key string, start, stop int) reply {
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	listData, errRep := db.getList(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if listData == nil {
+		return statusReply("OK")
+	}
+
+	// pop from both ends, trimming a capped list by a few elements is cheap
+	from, to := listRange(start, stop, listData.Len())
+	for listData.Len() > to {
+		listData.PopBack()
+	}
+	for i := 0; i < from; i++ {
+		listData.PopFront()
+	}
+	db.resized(key)
+	db.notify(notifyList, "ltrim", key)
+	db.deleteIfEmpty(key)
+	return statusReply("OK")
+}
+
+// Lpos returns the index of the rank-th occurrence of val, counting from the
+// tail when rank is negative, comparing at most maxLen elements (0 for no
+// limit). When count is not negative, the indexes of up to count occurrences
+// (all of them for 0) are returned instead.
+func (db *ledisDB) Lpos(key, val string, rank, count, maxLen int) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	listData, errRep := db.getList(key)
+	if errRep != nil {
+		return *errRep
+	}
+
+	matches := []reply{}
+	if listData != nil {
+		skip := rank - 1
+		step, start := 1, 0
+		if rank < 0 {
+			skip = -rank - 1
+			step, start = -1, listData.Len()-1
+		}
+		compared := 0
+		for i := start; i >= 0 && i < listData.Len(); i += step {
+			if maxLen > 0 && compared == maxLen {
+				break
+			}
+			compared++
+			if listData.Index(i) != val {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			matches = append(matches, intReply(i))
+			if (count < 0 && len(matches) == 1) || (count > 0 && len(matches) == count) {
+				break
+			}
+		}
+	}
+
+	if count >= 0 {
+		return arrayReply(matches)
+	}
+	if len(matches) == 0 {
+		if listData == nil {
+			return nilReply("key not found")
+		}
+		return nilReply("")
+	}
+	return matches[0]
+}
+
+// Lmove atomically moves an element from the head (popLeft) or the tail of
+// the list at src to the head (pushLeft) or the tail of the list at dest
+func (db *ledisDB) Lmove(src, dest string, popLeft, pushLeft bool) reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := db.lockKeys(src, dest)
+	defer unlock()
+
+	listData, errRep := db.getList(src)
+	if errRep != nil {
+		return *errRep
+	}
+	if listData == nil {
+		return nilReply("key not found")
+	}
+	return db.moveValue(src, dest, popLeft, pushLeft)
+}
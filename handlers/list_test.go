@@ -0,0 +1,238 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestListOps(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET testkey 123`, "OK", ""},
+		{`LPUSH testlist c b`, "2", "Test LPUSH"},
+		{`LPUSH testlist a`, "3", ""},
+		{`LRANGE testlist 0 10`, "a\r\nb\r\nc\r\n", "LPUSH prepends each value in turn"},
+		{`LPUSH testkey a`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`RPUSHX testlist d`, "4", "Test RPUSHX"},
+		{`LPUSHX testlist z`, "5", "Test LPUSHX"},
+		{`RPUSHX no-exist d`, "0", "RPUSHX does not create the list"},
+		{`LPUSHX no-exist d`, "0", ""},
+		{`LLEN no-exist`, "key not found", ""},
+
+		// LRANGE, stop is inclusive and negative indexes count from the tail
+		{`LRANGE testlist 0 1`, "z\r\na\r\n", "Test LRANGE"},
+		{`LRANGE testlist 0 -1`, "z\r\na\r\nb\r\nc\r\nd\r\n", ""},
+		{`LRANGE testlist -2 -1`, "c\r\nd\r\n", ""},
+		{`LRANGE testlist -100 1`, "z\r\na\r\n", "Start is clamped"},
+		{`LRANGE testlist 3 100`, "c\r\nd\r\n", "Stop is clamped"},
+		{`LRANGE testlist 2 2`, "b\r\n", ""},
+		{`LRANGE testlist 3 1`, "(empty list or set)", "Start after stop"},
+		{`LRANGE testlist 5 10`, "(empty list or set)", "Start after the end"},
+		{`LRANGE testlist -100 -10`, "(empty list or set)", ""},
+
+		// LINDEX
+		{`LINDEX testlist 0`, "z", "Test LINDEX"},
+		{`LINDEX testlist -1`, "d", "Negative index counts from the tail"},
+		{`LINDEX testlist 5`, "(nil)", ""},
+		{`LINDEX testlist -6`, "(nil)", ""},
+		{`LINDEX no-exist 0`, "key not found", ""},
+		{`LINDEX testkey 0`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+
+		// LSET
+		{`LSET testlist 0 y`, "OK", "Test LSET"},
+		{`LSET testlist -2 x`, "OK", ""},
+		{`LRANGE testlist 0 10`, "y\r\na\r\nb\r\nx\r\nd\r\n", ""},
+		{`LSET testlist 5 x`, "ERROR: index out of range", ""},
+		{`LSET no-exist 0 x`, "ERROR: no such key", ""},
+
+		// LINSERT
+		{`LINSERT testlist BEFORE a 1`, "6", "Test LINSERT"},
+		{`LINSERT testlist after d 2`, "7", ""},
+		{`LINSERT testlist AFTER nothing 2`, "-1", ""},
+		{`LINSERT no-exist AFTER a 2`, "key not found", ""},
+		{`LRANGE testlist 0 10`, "y\r\n1\r\na\r\nb\r\nx\r\nd\r\n2\r\n", ""},
+
+		// LREM
+		{`RPUSH dup a b a c a b a`, "7", ""},
+		{`LREM dup 2 a`, "2", "Remove from the head"},
+		{`LRANGE dup 0 10`, "b\r\nc\r\na\r\nb\r\na\r\n", ""},
+		{`LREM dup -1 b`, "1", "Remove from the tail"},
+		{`LRANGE dup 0 10`, "b\r\nc\r\na\r\na\r\n", ""},
+		{`LREM dup 0 a`, "2", "Remove all"},
+		{`LREM dup 0 nothing`, "0", ""},
+		{`LREM dup 0 b`, "1", ""},
+		{`LREM dup 0 c`, "1", ""},
+		{`LLEN dup`, "key not found", "Empty list is deleted"},
+		{`LREM no-exist 0 c`, "key not found", ""},
+
+		// LTRIM
+		{`RPUSH log 1 2 3 4 5 6`, "6", ""},
+		{`LTRIM log -4 -2`, "OK", "Test LTRIM"},
+		{`LRANGE log 0 10`, "3\r\n4\r\n5\r\n", ""},
+		{`LTRIM log 0 100`, "OK", ""},
+		{`LLEN log`, "3", ""},
+		{`LTRIM log 5 10`, "OK", ""},
+		{`LLEN log`, "key not found", "Trimming all elements deletes the list"},
+		{`LTRIM no-exist 0 1`, "OK", ""},
+		{`LTRIM testkey 0 1`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+
+		// LPOS
+		{`RPUSH pos a b c 1 2 3 c c`, "8", ""},
+		{`LPOS pos c`, "2", "Test LPOS"},
+		{`LPOS pos c RANK 2`, "6", ""},
+		{`LPOS pos c RANK -1`, "7", ""},
+		{`LPOS pos c COUNT 2`, "2\r\n6\r\n", ""},
+		{`LPOS pos c COUNT 0`, "2\r\n6\r\n7\r\n", ""},
+		{`LPOS pos c RANK -2 COUNT 0`, "6\r\n2\r\n", ""},
+		{`LPOS pos c COUNT 0 MAXLEN 6`, "2\r\n", ""},
+		{`LPOS pos c RANK -1 MAXLEN 1`, "7", ""},
+		{`LPOS pos nothing`, "(nil)", ""},
+		{`LPOS pos nothing COUNT 1`, "(empty list or set)", ""},
+		{`LPOS no-exist c`, "key not found", ""},
+		{`LPOS pos c RANK 0`, "ERROR: RANK can't be zero: use 1 to start from the first match, -1 from the last match", ""},
+		{`LPOS pos c COUNT -1`, "ERROR: COUNT can't be negative", ""},
+		{`LPOS pos c MAXLEN -1`, "ERROR: MAXLEN can't be negative", ""},
+		{`LPOS pos c RANK`, "ERROR: LPOS expects a key, an element and option value pairs", ""},
+		{`LPOS pos c RANK x`, "ERROR: Error when parsing RANK", ""},
+		{`LPOS pos c SOMETHING 1`, "ERROR: unknown LPOS option: SOMETHING", ""},
+
+		// LMOVE
+		{`RPUSH src 1 2 3`, "3", ""},
+		{`LMOVE src dst RIGHT LEFT`, "3", "Test LMOVE"},
+		{`LMOVE src dst LEFT RIGHT`, "1", ""},
+		{`LRANGE dst 0 10`, "3\r\n1\r\n", ""},
+		{`LMOVE src src LEFT RIGHT`, "2", "Rotate a single element list"},
+		{`LRANGE src 0 10`, "2\r\n", ""},
+		{`LMOVE src dst LEFT LEFT`, "2", ""},
+		{`LLEN src`, "key not found", ""},
+		{`LMOVE src dst LEFT LEFT`, "key not found", ""},
+		{`LMOVE dst testkey LEFT LEFT`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`LMOVE testkey dst LEFT LEFT`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`LRANGE dst 0 10`, "2\r\n3\r\n1\r\n", ""},
+	}
+	for _, test := range tests {
+		body := SendCommand(test.command)
+		g.Expect(body).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+}
+
+func TestInvalidListCommand(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{"LPUSH somekey", "ERROR: LPUSH expects at least 2 arguments", ""},
+		{"RPUSHX somekey", "ERROR: RPUSHX expects at least 2 arguments", ""},
+		{"LPUSHX somekey", "ERROR: LPUSHX expects at least 2 arguments", ""},
+		{"LINDEX somekey", "ERROR: LINDEX expects 2 arguments", ""},
+		{"LINDEX somekey a", "ERROR: Error when parsing index", ""},
+		{"LSET somekey 1", "ERROR: LSET expects 3 arguments", ""},
+		{"LSET somekey a b", "ERROR: Error when parsing index", ""},
+		{"LINSERT somekey BEFORE a", "ERROR: LINSERT expects 4 arguments", ""},
+		{"LINSERT somekey INSIDE a b", "ERROR: Error when parsing position, expects BEFORE or AFTER", ""},
+		{"LREM somekey 1", "ERROR: LREM expects 3 arguments", ""},
+		{"LREM somekey a b", "ERROR: Error when parsing count", ""},
+		{"LTRIM somekey 1", "ERROR: LTRIM expects 3 arguments", ""},
+		{"LTRIM somekey a 1", "ERROR: Error when parsing start", ""},
+		{"LTRIM somekey 1 a", "ERROR: Error when parsing stop", ""},
+		{"LMOVE a b LEFT", "ERROR: LMOVE expects 4 arguments", ""},
+		{"LMOVE a b UP LEFT", "ERROR: Error when parsing side, expects LEFT or RIGHT", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
+
+func TestListDeque(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	// random pushes and pops at both ends, checked against a slice, so that
+	// the ring buffer wraps around, grows and shrinks
+	rnd := rand.New(rand.NewSource(42))
+	model := []string{}
+	cmds := [][]string{}
+	expects := []string{}
+	for i := 0; i < 3000; i++ {
+		val := strconv.Itoa(i)
+		switch op := rnd.Intn(10); {
+		case op < 3:
+			model = append(model, val)
+			cmds = append(cmds, []string{"RPUSH", "deque", val})
+			expects = append(expects, strconv.Itoa(len(model)))
+		case op < 6:
+			model = append([]string{val}, model...)
+			cmds = append(cmds, []string{"LPUSH", "deque", val})
+			expects = append(expects, strconv.Itoa(len(model)))
+		case op < 8:
+			cmds = append(cmds, []string{"LPOP", "deque"})
+			if len(model) == 0 {
+				expects = append(expects, "key not found")
+				continue
+			}
+			expects = append(expects, model[0])
+			model = model[1:]
+		default:
+			cmds = append(cmds, []string{"RPOP", "deque"})
+			if len(model) == 0 {
+				expects = append(expects, "key not found")
+				continue
+			}
+			expects = append(expects, model[len(model)-1])
+			model = model[:len(model)-1]
+		}
+		if i%100 == 0 && len(model) > 0 {
+			idx := rnd.Intn(len(model))
+			cmds = append(cmds, []string{"LINDEX", "deque", strconv.Itoa(idx)})
+			expects = append(expects, model[idx])
+		}
+	}
+
+	body, _ := json.Marshal(cmds)
+	_, reply, errs := gorequest.New().Post(serverUrl).Type("json").SendString(string(body)).End()
+	g.Expect(errs).To(BeNil())
+	var results []string
+	g.Expect(json.Unmarshal([]byte(reply), &results)).To(Succeed())
+	g.Expect(results).To(Equal(expects))
+
+	expectRange := strings.Join(model, "\r\n") + "\r\n"
+	if len(model) == 0 {
+		expectRange = "key not found"
+	}
+	g.Expect(SendCommand(`LRANGE deque 0 -1`)).To(Equal(expectRange))
+
+	// trimming and removing keep working on a wrapped buffer
+	g.Expect(SendCommand(`DEL deque`)).To(Or(Equal("1"), Equal("key not found")))
+	SendCommand("RPUSH deque 5 6 7 8 9\nLPUSH deque 4 3 2 1 0\nLPOP deque\nRPOP deque")
+	g.Expect(SendCommand(`LTRIM deque 1 -2`)).To(Equal("OK"))
+	g.Expect(SendCommand(`LRANGE deque 0 -1`)).To(Equal("2\r\n3\r\n4\r\n5\r\n6\r\n7\r\n"))
+	g.Expect(SendCommand(`LINSERT deque BEFORE 5 x`)).To(Equal("7"))
+	g.Expect(SendCommand(`LREM deque 0 3`)).To(Equal("1"))
+	g.Expect(SendCommand(`LRANGE deque 0 -1`)).To(Equal("2\r\n4\r\nx\r\n5\r\n6\r\n7\r\n"))
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import "encoding/binary"
+
+// listpack is the compact representation of small lists and sets: their
+// elements packed one after another in a single byte slice, each prefixed
+// by its length as a uvarint. It saves the header and the allocation of
+// every element, at the price of walking the slice to reach one.
+type listpack []byte
+
+// entry returns the element at byte position pos and the position of the
+// next one
+func (lp listpack) entry(pos int) (string, int) {
+	raw, next := lp.raw(pos)
+	return string(raw), next
+}
+
+// raw is like entry, without copying the element
+func (lp listpack) raw(pos int) ([]byte, int) {
+	size, n := binary.Uvarint(lp[pos:])
+	start := pos + n
+	return lp[start : start+int(size)], start + int(size)
+}
+
+// find returns the byte position of the i-th element, or of the end of lp
+// when i is the number of elements
+func (lp listpack) find(i int) int {
+	pos := 0
+	for ; i > 0; i-- {
+		_, pos = lp.raw(pos)
+	}
+	return pos
+}
+
+// each calls fn for every element in order, until it returns false
+func (lp listpack) each(fn func(val string) bool) {
+	for pos := 0; pos < len(lp); {
+		var val string
+		val, pos = lp.entry(pos)
+		if !fn(val) {
+			return
+		}
+	}
+}
+
+// index returns the position of val, or -1
+func (lp listpack) index(val string) int {
+	for i, pos := 0, 0; pos < len(lp); i++ {
+		var elem []byte
+		elem, pos = lp.raw(pos)
+		if string(elem) == val {
+			return i
+		}
+	}
+	return -1
+}
+
+// splice replaces the bytes in [start, end) with the packed vals
+func (lp listpack) splice(start, end int, vals ...string) listpack {
+	packed := listpack{}
+	for _, val := range vals {
+		packed = packed.append(val)
+	}
+	result := make(listpack, 0, len(lp)-(end-start)+len(packed))
+	result = append(result, lp[:start]...)
+	result = append(result, packed...)
+	return append(result, lp[end:]...)
+}
+
+func (lp listpack) append(val string) listpack {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(val)))
+	lp = append(lp, size[:n]...)
+	return append(lp, val...)
+}
+
+func (lp listpack) values() []string {
+	vals := []string{}
+	lp.each(func(val string) bool {
+		vals = append(vals, val)
+		return true
+	})
+	return vals
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// estimated bytes used besides the raw bytes of keys and values: the map
+// entries, headers and metadata of a key, and the slot of a list element or
+// the map entry of a set member
+const (
+	keyOverhead     = 96
+	elemOverhead    = 16
+	memberOverhead  = 48
+	stringOverhead  = 16
+	defaultSamples  = 5
+	lfuInitCounter  = 5
+	lfuLogFactor    = 10
+	lfuDecayMinutes = 1
+)
+
+const oomMsg = "OOM command not allowed when used memory > 'maxmemory'."
+
+// ErrOOM is returned by writes when the used memory is over maxmemory and
+// no key can be evicted
+var ErrOOM = errors.New(oomMsg)
+
+// eviction policies, see the maxmemory-policy setting
+const (
+	noEviction = iota
+	allKeysLRU
+	allKeysLFU
+	allKeysRandom
+	volatileLRU
+	volatileLFU
+	volatileRandom
+	volatileTTL
+)
+
+var evictionPolicies = []string{
+	noEviction:     "noeviction",
+	allKeysLRU:     "allkeys-lru",
+	allKeysLFU:     "allkeys-lfu",
+	allKeysRandom:  "allkeys-random",
+	volatileLRU:    "volatile-lru",
+	volatileLFU:    "volatile-lfu",
+	volatileRandom: "volatile-random",
+	volatileTTL:    "volatile-ttl",
+}
+
+func oomReply() reply {
+	return reply{kind: replyError, str: oomMsg}
+}
+
+// growing evicts keys before a command that may use more memory, once its
+// arguments are parsed so that a malformed command evicts nothing. It
+// returns the OOM error reply when the used memory can't get within
+// maxmemory. No shard lock must be held.
+func (db *ledisDB) growing() *reply {
+	if err := db.freeMemory(); err != nil {
+		rep := oomReply()
+		return &rep
+	}
+	return nil
+}
+
+// keyMeta is the metadata of a key, shared by the copies of its LedisData
+// and carried along by RENAME and MOVE
+type keyMeta struct {
+	// size is the estimated size of the value, guarded by the shard lock
+	size int64
+
+	// access is the unix time in nanoseconds of the last access, and freq
+	// the logarithmic access counter of LFU. They are updated atomically
+	// under the read lock too.
+	access int64
+	freq   uint32
+}
+
+func newKeyMeta(size int64) *keyMeta {
+	return &keyMeta{size: size, access: time.Now().UnixNano(), freq: lfuInitCounter}
+}
+
+// touch records an access to the key. The LFU counter first decays, see
+// frequency, then grows with a probability falling as it gets higher, so
+// that 255 stands for about a million accesses.
+func (meta *keyMeta) touch() {
+	now := time.Now().UnixNano()
+	freq := meta.frequency(now)
+	atomic.StoreInt64(&meta.access, now)
+	if freq < 255 {
+		base := float64(0)
+		if freq > lfuInitCounter {
+			base = float64(freq - lfuInitCounter)
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+	atomic.StoreUint32(&meta.freq, freq)
+}
+
+// frequency returns the LFU counter at now, decayed by one per minute since
+// the last access
+func (meta *keyMeta) frequency(now int64) uint32 {
+	freq := atomic.LoadUint32(&meta.freq)
+	decay := (now - atomic.LoadInt64(&meta.access)) / int64(time.Minute) / lfuDecayMinutes
+	if decay >= int64(freq) {
+		return 0
+	}
+	return freq - uint32(decay)
+}
+
+// idle returns the time since the last access
+func (meta *keyMeta) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&meta.access))
+}
+
+// valueSize estimates the memory used by val in its encoding. Shared
+// integers are free.
+func valueSize(val LedisData) int64 {
+	switch val.DataType {
+	case TypeString:
+		if shared(val.StringData) {
+			return 0
+		}
+		return int64(len(*val.StringData) + stringOverhead)
+	case TypeList:
+		return val.ListData.memory()
+	default:
+		return val.SetData.memory()
+	}
+}
+
+func elemSize(elem string) int64 {
+	return int64(len(elem) + elemOverhead)
+}
+
+func memberSize(member string) int64 {
+	return int64(len(member) + memberOverhead)
+}
+
+func keySize(key string, meta *keyMeta) int64 {
+	return int64(len(key)+keyOverhead) + meta.size
+}
+
+// resized converts the collection at key to a less compact encoding once
+// it is over the limits, and accounts for the change of its size. It is
+// called after changing the collection in place, with the shard lock of key
+// held.
+func (db *ledisDB) resized(key string) {
+	s := db.shardOf(key)
+	val, ok := s.Data[key]
+	if !ok {
+		return
+	}
+	val.encode(db.limits())
+	size := valueSize(val)
+	s.account(size - val.meta.size)
+	val.meta.size = size
+}
+
+// account adds delta to the bytes used by shard s, whose lock must be held
+func (s *shard) account(delta int64) {
+	s.used += delta
+	atomic.AddInt64(&s.db.used, delta)
+}
+
+// freeMemory evicts keys, as the maxmemory policy says, until the used
+// memory is within maxmemory. It fails with ErrOOM when no key can be
+// evicted. No shard lock must be held.
+func (store *LedisStore) freeMemory() error {
+	maxMemory := atomic.LoadInt64(&store.maxMemory)
+	if maxMemory == 0 {
+		return nil
+	}
+	for atomic.LoadInt64(&store.used) > maxMemory {
+		policy := int(atomic.LoadInt32(&store.maxMemoryPolicy))
+		if policy == noEviction || !store.evict(policy) {
+			return ErrOOM
+		}
+	}
+	return nil
+}
+
+// evictionCandidate is a key sampled for eviction, the one with the highest
+// score is evicted
+type evictionCandidate struct {
+	db    *ledisDB
+	key   string
+	score float64
+}
+
+// evict evicts one key picked by policy among a few sampled ones, like
+// Redis does to approximate LRU and LFU without ordering all the keys. It
+// reports false when there is no key to evict.
+func (store *LedisStore) evict(policy int) bool {
+	volatile := policy >= volatileLRU
+	shards := []*shard{}
+	for _, s := range allShards(store.dbs) {
+		if s.candidates(volatile) > 0 {
+			shards = append(shards, s)
+		}
+	}
+	if len(shards) == 0 {
+		return false
+	}
+
+	var best *evictionCandidate
+	samples := int(atomic.LoadInt32(&store.maxMemorySamples))
+	for i := 0; i < samples; i++ {
+		candidate, ok := shards[rand.Intn(len(shards))].sample(policy, volatile)
+		if ok && (best == nil || candidate.score > best.score) {
+			best = &candidate
+		}
+	}
+	if best == nil {
+		// the keys sampled were deleted meanwhile, give up rather than
+		// looping in freeMemory if they keep being
+		return false
+	}
+
+	unlock := best.db.lockKeys(best.key)
+	defer unlock()
+	if _, ok := best.db.shardOf(best.key).Data[best.key]; !ok {
+		// deleted meanwhile, which freed memory too
+		return true
+	}
+	best.db.remove(best.key)
+	atomic.AddInt64(&store.stats.evictedKeys, 1)
+	best.db.notify(notifyEvicted, "evicted", best.key)
+	return true
+}
+
+// candidates returns the number of keys of the shard that can be evicted
+func (s *shard) candidates(volatile bool) int {
+	release := acquire(false, []*shard{s})
+	defer release()
+	if volatile {
+		return len(s.ExpireTime)
+	}
+	return len(s.Data)
+}
+
+// sample picks a key of the shard, one with an expiration when volatile,
+// and scores it for policy
+func (s *shard) sample(policy int, volatile bool) (evictionCandidate, bool) {
+	release := acquire(false, []*shard{s})
+	defer release()
+
+	candidate := evictionCandidate{db: s.db}
+	found := false
+	if volatile {
+		// map iteration starts at random
+		for key := range s.ExpireTime {
+			candidate.key, found = key, true
+			break
+		}
+	} else {
+		for key := range s.Data {
+			candidate.key, found = key, true
+			break
+		}
+	}
+	if !found {
+		return candidate, false
+	}
+
+	val, ok := s.Data[candidate.key]
+	if !ok {
+		return candidate, false
+	}
+	meta := val.meta
+	switch policy {
+	case allKeysLRU, volatileLRU:
+		candidate.score = float64(meta.idle())
+	case allKeysLFU, volatileLFU:
+		candidate.score = float64(255 - meta.frequency(time.Now().UnixNano()))
+	case volatileTTL:
+		// the sooner to expire, the higher the score
+		candidate.score = -float64(s.ExpireTime[candidate.key])
+	default:
+		candidate.score = rand.Float64()
+	}
+	return candidate, true
+}
+
+// parseMemory parses a number of bytes, with an optional unit like 100mb
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	value = strings.ToLower(value)
+	mult := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, mult = strings.TrimSuffix(value, unit.suffix), unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * mult, nil
+}
+
+// memoryCommand parses MEMORY USAGE key [SAMPLES count] and MEMORY STATS
+func memoryCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 1 {
+		return errorReply(fmt.Errorf("MEMORY expects at least 1 argument"))
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "USAGE":
+		if len(cmd.Args) != 2 && len(cmd.Args) != 4 {
+			return errorReply(fmt.Errorf("MEMORY USAGE expects 1 or 3 arguments"))
+		}
+		if len(cmd.Args) == 4 {
+			// sizes are kept up to date, there is nothing to sample, but
+			// the option is checked for compatibility
+			samples, err := strconv.Atoi(cmd.Args[3])
+			if strings.ToUpper(cmd.Args[2]) != "SAMPLES" || err != nil || samples < 0 {
+				return errorReply(fmt.Errorf("syntax error"))
+			}
+		}
+		return db.MemoryUsage(cmd.Args[1])
+	case "STATS":
+		if len(cmd.Args) != 1 {
+			return errorReply(fmt.Errorf("MEMORY STATS expects no argument"))
+		}
+		return db.MemoryStats()
+	default:
+		return errorReply(fmt.Errorf("unknown MEMORY subcommand: %s", cmd.Args[0]))
+	}
+}
+
+// MemoryUsage returns the estimated bytes used by key and its value,
+// overheads included
+func (db *ledisDB) MemoryUsage(key string) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+
+	val, ok := db.peek(key)
+	if !ok {
+		return nilReply("key not found")
+	}
+	return intReply(int(keySize(key, val.meta)))
+}
+
+// MemoryStats returns the memory used by the keys of the store, in total
+// and by type, as name and value pairs
+func (db *ledisDB) MemoryStats() reply {
+	unlock := rlockAll(db.dbs...)
+	defer unlock()
+
+	types := []ledisType{TypeString, TypeList, TypeSet}
+	keys := map[ledisType]int{}
+	bytes := map[ledisType]int64{}
+	total, expires := 0, 0
+	for _, d := range db.dbs {
+		d.each(func(key string, val LedisData) {
+			keys[val.DataType]++
+			bytes[val.DataType] += keySize(key, val.meta)
+		})
+		total += d.size()
+		for _, s := range d.shards {
+			expires += len(s.ExpireTime)
+		}
+	}
+
+	used := atomic.LoadInt64(&db.used)
+	perKey := int64(0)
+	if total > 0 {
+		perKey = used / int64(total)
+	}
+	stats := []reply{
+		bulkReply("dataset.bytes"), intReply(int(used)),
+		bulkReply("maxmemory"), intReply(int(atomic.LoadInt64(&db.maxMemory))),
+		bulkReply("keys.count"), intReply(total),
+		bulkReply("keys.expires"), intReply(expires),
+		bulkReply("keys.bytes-per-key"), intReply(int(perKey)),
+	}
+	for _, dataType := range types {
+		name := typeName(dataType) + "s"
+		stats = append(stats,
+			bulkReply(name+".count"), intReply(keys[dataType]),
+			bulkReply(name+".bytes"), intReply(int(bytes[dataType])))
+	}
+	return arrayReply(stats)
+}
+
+func parseEvictionPolicy(value string) (int, error) {
+	for policy, name := range evictionPolicies {
+		if strings.ToLower(value) == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown eviction policy")
+}
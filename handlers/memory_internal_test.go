@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestEvictOrphanExpiration(t *testing.T) {
+	store := NewLedisStore()
+	defer store.Close()
+	g := NewGomegaWithT(t)
+	db := store.dbs[0]
+
+	// expirations without a key used to make the volatile policies sample
+	// nothing forever
+	value := strings.Repeat("x", 100)
+	db.put("persistent", LedisData{DataType: TypeString, StringData: &value})
+	db.restore(snapshotDB{ExpireTime: map[string]int64{"ghost": time.Now().Add(time.Hour).UnixNano()}})
+	g.Expect(db.shardOf("ghost").ExpireTime).NotTo(HaveKey("ghost"), "Restore drops expirations of missing keys")
+
+	db.setExpireTime("ghost", time.Now().Add(time.Hour).UnixNano())
+	store.maxMemory = 1
+	store.maxMemoryPolicy = volatileLRU
+	done := make(chan error)
+	go func() {
+		done <- store.freeMemory()
+	}()
+	g.Eventually(done, time.Second).Should(Receive(Equal(ErrOOM)))
+}
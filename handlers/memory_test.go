@@ -0,0 +1,141 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMaxMemory(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`CONFIG GET maxmemory*`, "maxmemory\r\n0\r\nmaxmemory-policy\r\nnoeviction\r\nmaxmemory-samples\r\n5\r\n", ""},
+		{`CONFIG SET maxmemory lots`, "ERROR: invalid argument 'lots' for CONFIG SET 'maxmemory': argument must be a memory value", ""},
+		{`CONFIG SET maxmemory-policy allkeys-oldest`, "ERROR: invalid argument 'allkeys-oldest' for CONFIG SET 'maxmemory-policy': unknown eviction policy", ""},
+		{`CONFIG SET maxmemory-samples 0`, "ERROR: invalid argument '0' for CONFIG SET 'maxmemory-samples': argument must be between 1 and 64", ""},
+		{`CONFIG SET maxmemory 2kb`, "OK", ""},
+		{`CONFIG GET maxmemory`, "maxmemory\r\n2048\r\n", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	// noeviction fails the writes once over the limit, reads and deletes
+	// still work
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 20; i++ {
+		SendCommand(fmt.Sprintf(`SET key%d %s`, i, value))
+	}
+	g.Expect(SendCommand(`SET one-more ` + value)).To(Equal("OOM command not allowed when used memory > 'maxmemory'."))
+	g.Expect(SendCommand(`RPUSH list`)).To(Equal("ERROR: RPUSH expects at least 2 arguments"), "Arguments are checked first")
+	g.Expect(SendCommand(`LINSERT list AROUND a b`)).To(Equal("ERROR: Error when parsing position, expects BEFORE or AFTER"))
+	db, _ := store.DB(0)
+	g.Expect(db.Set("api", []byte(value))).To(Equal(handlers.ErrOOM))
+	g.Expect(SendCommand(`GET key0`)).To(Equal(value))
+	g.Expect(SendCommand(`DEL key0 key1 key2 key3 key4`)).To(Equal("5"))
+	g.Expect(SendCommand(`SET one-more ` + value)).To(Equal("OK"))
+
+	// allkeys-lru evicts keys to make room, sparing recently used ones
+	g.Expect(SendCommand(`FLUSHALL`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory-policy allkeys-lru`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory-samples 64`)).To(Equal("OK"))
+	g.Expect(SendCommand(`SET hot ` + value)).To(Equal("OK"))
+	for i := 0; i < 100; i++ {
+		g.Expect(SendCommand(fmt.Sprintf(`SET key%d %s`, i, value))).To(Equal("OK"))
+		SendCommand(`GET hot`)
+	}
+	size, _ := strconv.Atoi(SendCommand(`DBSIZE`))
+	g.Expect(size).To(BeNumerically("<", 11), "Keys were evicted")
+	g.Expect(SendCommand(`GET hot`)).To(Equal(value), "The most recently used key survives")
+	g.Expect(SendCommand(`GET key99`)).To(Equal(value), "The key just set survives")
+	g.Expect(SendCommand(`CONFIG SET maxmemory 1`)).To(Equal("OK"))
+	for _, command := range []string{`SET key99`, `RPUSH key99`, `SADD key99`, `LSET key99 x y`, `BLMOVE a b LEFT UP 0`, `COPY key99`} {
+		g.Expect(SendCommand(command)).To(HavePrefix("ERROR: "), command)
+	}
+	g.Expect(SendCommand(`GET key99`)).To(Equal(value), "Malformed commands evict nothing")
+	g.Expect(SendCommand(`CONFIG SET maxmemory 2kb`)).To(Equal("OK"))
+
+	// volatile-ttl only evicts keys with an expiration, the soonest first
+	g.Expect(SendCommand(`FLUSHALL`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG SET maxmemory-policy volatile-ttl`)).To(Equal("OK"))
+	g.Expect(SendCommand(`SET persistent ` + value)).To(Equal("OK"))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		g.Expect(SendCommand(`SET ` + key + ` ` + value)).To(Equal("OK"))
+		ttl := strconv.Itoa(1000 + i)
+		g.Expect(SendCommand(`EXPIRE ` + key + ` ` + ttl)).To(Equal(ttl))
+	}
+	g.Expect(SendCommand(`GET persistent`)).To(Equal(value))
+	g.Expect(SendCommand(`GET key0`)).To(Equal("key not found"))
+	g.Expect(SendCommand(`GET key98`)).To(Equal(value))
+
+	// with no volatile key left to evict, writes fail
+	g.Expect(SendCommand(`FLUSHALL`)).To(Equal("OK"))
+	for i := 0; i < 20; i++ {
+		SendCommand(fmt.Sprintf(`RPUSH list %s`, value))
+	}
+	g.Expect(SendCommand(`RPUSH list ` + value)).To(Equal("OOM command not allowed when used memory > 'maxmemory'."))
+}
+
+func TestMemoryIntrospection(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET str abc`, "OK", ""},
+		{`RPUSH list a bb`, "2", ""},
+		{`SADD set x`, "1", ""},
+		{`EXPIRE set 100`, "100", ""},
+		{`MEMORY USAGE str`, "118", "Key, value and overheads"},
+		{`MEMORY USAGE list SAMPLES 0`, "105", ""},
+		{`RPUSH list ccc`, "3", ""},
+		{`MEMORY USAGE list`, "109", "Sizes follow the changes"},
+		{`MEMORY USAGE set`, "101", ""},
+		{`MEMORY USAGE no-exist`, "key not found", ""},
+		{`MEMORY USAGE str SAMPLE 5`, "ERROR: syntax error", ""},
+		{`MEMORY STATS`, "dataset.bytes\r\n328\r\nmaxmemory\r\n0\r\nkeys.count\r\n3\r\nkeys.expires\r\n1\r\nkeys.bytes-per-key\r\n109\r\n" +
+			"strings.count\r\n1\r\nstrings.bytes\r\n118\r\nlists.count\r\n1\r\nlists.bytes\r\n109\r\nsets.count\r\n1\r\nsets.bytes\r\n101\r\n", ""},
+		{`MEMORY DOCTOR`, "ERROR: unknown MEMORY subcommand: DOCTOR", ""},
+		{`OBJECT ENCODING str`, "raw", ""},
+		{`OBJECT ENCODING list`, "listpack", ""},
+		{`OBJECT ENCODING set`, "listpack", ""},
+		{`OBJECT REFCOUNT str`, "1", ""},
+		{`OBJECT IDLETIME str`, "0", ""},
+		{`OBJECT FREQ no-exist`, "key not found", ""},
+		{`OBJECT HELP`, "ERROR: OBJECT expects 2 arguments", ""},
+		{`DEL list`, "1", ""},
+		{`MEMORY STATS`, "dataset.bytes\r\n219\r\nmaxmemory\r\n0\r\nkeys.count\r\n2\r\nkeys.expires\r\n1\r\nkeys.bytes-per-key\r\n109\r\n" +
+			"strings.count\r\n1\r\nstrings.bytes\r\n118\r\nlists.count\r\n0\r\nlists.bytes\r\n0\r\nsets.count\r\n1\r\nsets.bytes\r\n101\r\n", "Deleted keys are no longer counted"},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	// accesses raise the LFU counter, OBJECT itself is not an access
+	g.Expect(SendCommand(`OBJECT FREQ str`)).To(Equal("5"))
+	for i := 0; i < 100; i++ {
+		SendCommand(`GET str`)
+	}
+	freq, _ := strconv.Atoi(SendCommand(`OBJECT FREQ str`))
+	g.Expect(freq).To(BeNumerically(">", 5))
+	time.Sleep(1100 * time.Millisecond)
+	g.Expect(SendCommand(`OBJECT IDLETIME str`)).To(Equal("1"))
+	g.Expect(SendCommand(`OBJECT FREQ str`)).To(Equal(strconv.Itoa(freq)))
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// upper bounds, in seconds, of the buckets of the latency histograms
+var (
+	commandBuckets  = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+	snapshotBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60}
+)
+
+// kinds of locks waited for, the executor is waited for in serial mode
+const (
+	lockRead = iota
+	lockWrite
+	lockExecutor
+)
+
+var lockKinds = []string{lockRead: "read", lockWrite: "write", lockExecutor: "executor"}
+
+// storeMetrics are the metrics exported for Prometheus by MetricsHandler,
+// on top of the stats also reported by INFO. They are updated without
+// locking so that commands do not contend on them.
+type storeMetrics struct {
+	// commands holds the *histogram of the latencies of each command, by
+	// upper case name
+	commands sync.Map
+	// errors holds the *int64 count of error replies by type, the first
+	// word of the error, like ERR or WRONGTYPE
+	errors sync.Map
+
+	// time spent waiting for each kind of lock, in nanoseconds, and the
+	// number of times it was acquired
+	lockWait     [3]int64
+	lockAcquired [3]int64
+
+	// durations of SAVE and RESTORE, and the size of the last snapshot file
+	// written or read
+	saves        *histogram
+	restores     *histogram
+	snapshotSize int64
+}
+
+func newStoreMetrics() storeMetrics {
+	return storeMetrics{
+		saves:    newHistogram(snapshotBuckets),
+		restores: newHistogram(snapshotBuckets),
+	}
+}
+
+// commandDone records the latency and the reply of a command
+func (metrics *storeMetrics) commandDone(cmd *command, rep reply, elapsed time.Duration) {
+	name := strings.ToUpper(cmd.Name)
+	if rep.kind == replyError && strings.HasPrefix(rep.str, "ERR "+unknownCommandMsg) {
+		// do not create a series per misspelled command
+		name = "UNKNOWN"
+	}
+	latencies, ok := metrics.commands.Load(name)
+	if !ok {
+		latencies, _ = metrics.commands.LoadOrStore(name, newHistogram(commandBuckets))
+	}
+	latencies.(*histogram).observe(elapsed)
+
+	if rep.kind == replyError {
+		errorType := strings.SplitN(rep.str, " ", 2)[0]
+		count, ok := metrics.errors.Load(errorType)
+		if !ok {
+			count, _ = metrics.errors.LoadOrStore(errorType, new(int64))
+		}
+		atomic.AddInt64(count.(*int64), 1)
+	}
+}
+
+// lockWaited records the time spent waiting for a lock of kind
+func (metrics *storeMetrics) lockWaited(kind int, waited time.Duration) {
+	atomic.AddInt64(&metrics.lockWait[kind], int64(waited))
+	atomic.AddInt64(&metrics.lockAcquired[kind], 1)
+}
+
+// histogram counts observed durations in buckets, as a Prometheus histogram
+type histogram struct {
+	bounds []float64
+	// counts has a bucket for each bound and a last one for +Inf, they are
+	// not cumulative
+	counts []uint64
+	// sum is in nanoseconds
+	sum int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(elapsed time.Duration) {
+	atomic.AddUint64(&h.counts[sort.SearchFloat64s(h.bounds, elapsed.Seconds())], 1)
+	atomic.AddInt64(&h.sum, int64(elapsed))
+}
+
+// count returns the number of observations
+func (h *histogram) count() uint64 {
+	total := uint64(0)
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	return total
+}
+
+// MetricsHandler exports the metrics of its store in the Prometheus text
+// exposition format
+type MetricsHandler struct {
+	store *LedisStore
+}
+
+func NewMetricsHandler(store *LedisStore) *MetricsHandler {
+	return &MetricsHandler{store: store}
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	io.WriteString(w, h.store.dbs[0].metricsText())
+}
+
+// metricsWriter writes metric families in the text exposition format
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) family(name, kind, help string) {
+	w.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + kind + "\n")
+}
+
+// sample writes a sample of name, labels are given as name and value pairs
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	for i := 0; i < len(labels); i += 2 {
+		if i == 0 {
+			w.WriteString("{")
+		} else {
+			w.WriteString(",")
+		}
+		w.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		if i == len(labels)-2 {
+			w.WriteString("}")
+		}
+	}
+	w.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// histogram writes the buckets, sum and count of h
+func (w *metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	cumulative := uint64(0)
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'f', -1, 64)
+		}
+		w.sample(name+"_bucket", float64(cumulative), append(labels, "le", le)...)
+	}
+	w.sample(name+"_sum", time.Duration(atomic.LoadInt64(&h.sum)).Seconds(), labels...)
+	w.sample(name+"_count", float64(cumulative), labels...)
+}
+
+// metricsText returns the metrics of the store in the text exposition
+// format
+func (db *ledisDB) metricsText() string {
+	metrics, stats := &db.metrics, &db.stats
+	w := &metricsWriter{}
+	counter := func(name, help string, value int64) {
+		w.family(name, "counter", help)
+		w.sample(name, float64(value))
+	}
+	gauge := func(name, help string, value int64) {
+		w.family(name, "gauge", help)
+		w.sample(name, float64(value))
+	}
+
+	gauge("ledis_uptime_seconds", "Seconds since the store was created.", int64(time.Since(stats.started)/time.Second))
+	gauge("ledis_connected_clients", "Number of clients connected.", atomic.LoadInt64(&stats.connectedClients))
+
+	names := []string{}
+	metrics.commands.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	w.family("ledis_commands_total", "counter", "Number of calls of each command.")
+	for _, name := range names {
+		latencies, _ := metrics.commands.Load(name)
+		w.sample("ledis_commands_total", float64(latencies.(*histogram).count()), "command", name)
+	}
+	w.family("ledis_command_duration_seconds", "histogram", "Latency of the commands, without the time spent blocked on lists.")
+	for _, name := range names {
+		latencies, _ := metrics.commands.Load(name)
+		w.histogram("ledis_command_duration_seconds", latencies.(*histogram), "command", name)
+	}
+
+	errorTypes := []string{}
+	metrics.errors.Range(func(errorType, _ interface{}) bool {
+		errorTypes = append(errorTypes, errorType.(string))
+		return true
+	})
+	sort.Strings(errorTypes)
+	w.family("ledis_command_errors_total", "counter", "Number of error replies by type.")
+	for _, errorType := range errorTypes {
+		count, _ := metrics.errors.Load(errorType)
+		w.sample("ledis_command_errors_total", float64(atomic.LoadInt64(count.(*int64))), "type", errorType)
+	}
+
+	db.writeKeyMetrics(w)
+	counter("ledis_expired_keys_total", "Number of keys removed once expired.", atomic.LoadInt64(&stats.expiredKeys))
+	counter("ledis_evicted_keys_total", "Number of keys evicted by the maxmemory policy.", atomic.LoadInt64(&stats.evictedKeys))
+	counter("ledis_keyspace_hits_total", "Number of reads of existing keys.", atomic.LoadInt64(&stats.keyspaceHits))
+	counter("ledis_keyspace_misses_total", "Number of reads of missing keys.", atomic.LoadInt64(&stats.keyspaceMisses))
+	gauge("ledis_memory_used_bytes", "Estimated memory used by the keys.", atomic.LoadInt64(&db.used))
+	gauge("ledis_memory_max_bytes", "Memory limit, 0 for none.", atomic.LoadInt64(&db.maxMemory))
+
+	w.family("ledis_snapshot_duration_seconds", "histogram", "Duration of the snapshots saved and restored.")
+	w.histogram("ledis_snapshot_duration_seconds", metrics.saves, "operation", "save")
+	w.histogram("ledis_snapshot_duration_seconds", metrics.restores, "operation", "restore")
+	gauge("ledis_snapshot_size_bytes", "Size of the last snapshot file saved or restored.", atomic.LoadInt64(&metrics.snapshotSize))
+	gauge("ledis_changes_since_last_save", "Number of changes since the last snapshot.", atomic.LoadInt64(&stats.dirty))
+
+	w.family("ledis_lock_wait_seconds_total", "counter", "Time spent waiting for the shard locks, or for the executor in executor mode.")
+	for kind, name := range lockKinds {
+		w.sample("ledis_lock_wait_seconds_total", time.Duration(atomic.LoadInt64(&metrics.lockWait[kind])).Seconds(), "lock", name)
+	}
+	w.family("ledis_lock_acquisitions_total", "counter", "Number of acquisitions of the shard locks, or of the executor.")
+	for kind, name := range lockKinds {
+		w.sample("ledis_lock_acquisitions_total", float64(atomic.LoadInt64(&metrics.lockAcquired[kind])), "lock", name)
+	}
+	return w.String()
+}
+
+// writeKeyMetrics writes the number of keys of each type and of keys with an
+// expiration, for every non empty database. It reads the counts of the
+// shards without locking them.
+func (db *ledisDB) writeKeyMetrics(w *metricsWriter) {
+	types := []ledisType{TypeString, TypeList, TypeSet}
+	keys := make([][3]int64, len(db.dbs))
+	expires := make([]int64, len(db.dbs))
+	for i, d := range db.dbs {
+		for _, s := range d.shards {
+			for _, dataType := range types {
+				keys[i][dataType] += atomic.LoadInt64(&s.keys[dataType])
+			}
+			expires[i] += atomic.LoadInt64(&s.expiring)
+		}
+	}
+	empty := func(i int) bool {
+		return keys[i] == [3]int64{}
+	}
+
+	w.family("ledis_keys", "gauge", "Number of keys by database and type.")
+	for i, d := range db.dbs {
+		if empty(i) {
+			continue
+		}
+		for _, dataType := range types {
+			w.sample("ledis_keys", float64(keys[i][dataType]), "db", strconv.Itoa(d.index), "type", typeName(dataType))
+		}
+	}
+	w.family("ledis_expiring_keys", "gauge", "Number of keys with an expiration by database.")
+	for i, d := range db.dbs {
+		if !empty(i) {
+			w.sample("ledis_expiring_keys", float64(expires[i]), "db", strconv.Itoa(d.index))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// scrape returns the samples exported by the metrics server, by name and
+// labels
+func scrape(g *GomegaWithT, url string) map[string]float64 {
+	resp, err := http.Get(url)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	samples := map[string]float64{}
+	for _, match := range regexp.MustCompile(`(?m)^([a-z_]+(?:\{[^}]*\})?) (\S+)$`).FindAllStringSubmatch(string(body), -1) {
+		value, err := strconv.ParseFloat(match[2], 64)
+		g.Expect(err).NotTo(HaveOccurred(), match[0])
+		samples[match[1]] = value
+	}
+	g.Expect(string(body)).To(ContainSubstring("# TYPE ledis_command_duration_seconds histogram\n"))
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	for _, options := range [][]handlers.StoreOption{nil, {handlers.WithExecutor()}} {
+		store := handlers.NewLedisStore(options...)
+		server := httptest.NewServer(handlers.NewLedisHandler(store))
+		metrics := httptest.NewServer(handlers.NewMetricsHandler(store))
+		serverUrl = server.URL
+		g := NewGomegaWithT(t)
+
+		tests := []ValidateExactTest{
+			{`SET key a`, "OK", ""},
+			{`GET key`, "a", ""},
+			{`get key`, "a", "Commands are counted by upper case name"},
+			{`RPUSH list a b`, "2", ""},
+			{`SADD set a`, "1", ""},
+			{`EXPIRE set 100`, "100", ""},
+			{`LPOP key`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+			{`GET`, "ERROR: GET expects 1 argument", ""},
+			{`NOSUCHCOMMAND`, "ERROR: unkonwn command: NOSUCHCOMMAND", ""},
+			{`SAVE`, "OK", ""},
+			{`RESTORE`, "OK", ""},
+		}
+		for _, test := range tests {
+			g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+		}
+		g.Expect(SendDBCommand("3", `SET other b`)).To(Equal("OK"))
+
+		samples := scrape(g, metrics.URL)
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_commands_total{command="GET"}`, 3.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_commands_total{command="SET"}`, 2.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_commands_total{command="UNKNOWN"}`, 1.0))
+		g.Expect(samples).NotTo(HaveKey(`ledis_commands_total{command="NOSUCHCOMMAND"}`))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_command_duration_seconds_count{command="GET"}`, 3.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_command_duration_seconds_bucket{command="GET",le="+Inf"}`, 3.0))
+		g.Expect(samples[`ledis_command_duration_seconds_bucket{command="GET",le="1"}`]).To(Equal(3.0))
+		g.Expect(samples[`ledis_command_duration_seconds_sum{command="GET"}`]).To(BeNumerically(">", 0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_command_errors_total{type="ERR"}`, 2.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_command_errors_total{type="WRONGTYPE"}`, 1.0))
+
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="0",type="string"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="0",type="list"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="0",type="set"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="3",type="string"}`, 1.0))
+		g.Expect(samples).NotTo(HaveKey(`ledis_keys{db="1",type="string"}`))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_expiring_keys{db="0"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_expiring_keys{db="3"}`, 0.0))
+		g.Expect(samples).To(HaveKeyWithValue("ledis_expired_keys_total", 0.0))
+		g.Expect(samples).To(HaveKeyWithValue("ledis_keyspace_hits_total", 2.0))
+		g.Expect(samples["ledis_memory_used_bytes"]).To(BeNumerically(">", 0))
+
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_snapshot_duration_seconds_count{operation="save"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_snapshot_duration_seconds_count{operation="restore"}`, 1.0))
+		g.Expect(samples["ledis_snapshot_size_bytes"]).To(BeNumerically(">", 0))
+		if options == nil {
+			g.Expect(samples[`ledis_lock_acquisitions_total{lock="write"}`]).To(BeNumerically(">=", 5))
+			g.Expect(samples[`ledis_lock_acquisitions_total{lock="read"}`]).To(BeNumerically(">=", 2))
+			g.Expect(samples).To(HaveKeyWithValue(`ledis_lock_acquisitions_total{lock="executor"}`, 0.0))
+		} else {
+			g.Expect(samples[`ledis_lock_acquisitions_total{lock="executor"}`]).To(BeNumerically(">=", len(tests)))
+			g.Expect(samples).To(HaveKeyWithValue(`ledis_lock_acquisitions_total{lock="write"}`, 0.0))
+		}
+
+		// the key counts follow type changes, deletes and whole databases
+		for _, test := range []ValidateExactTest{
+			{`SET list x`, "OK", ""},
+			{`DEL key`, "1", ""},
+			{`SWAPDB 0 3`, "OK", ""},
+		} {
+			g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+		}
+		g.Expect(SendDBCommand("3", `EXPIRE list 100`)).To(Equal("100"))
+		g.Expect(SendDBCommand("3", `DEL set`)).To(Equal("1"))
+		samples = scrape(g, metrics.URL)
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="0",type="string"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="3",type="string"}`, 1.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="3",type="list"}`, 0.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_keys{db="3",type="set"}`, 0.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_expiring_keys{db="0"}`, 0.0))
+		g.Expect(samples).To(HaveKeyWithValue(`ledis_expiring_keys{db="3"}`, 1.0))
+		g.Expect(SendDBCommand("3", `FLUSHDB`)).To(Equal("OK"))
+		samples = scrape(g, metrics.URL)
+		g.Expect(samples).NotTo(HaveKey(`ledis_keys{db="3",type="string"}`))
+		g.Expect(samples).NotTo(HaveKey(`ledis_expiring_keys{db="3"}`))
+
+		metrics.Close()
+		server.Close()
+		store.Close()
+	}
+}
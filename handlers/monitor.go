@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a monitor is disconnected as soon as it has this many lines waiting to be
+// written
+const monitorBufferLines = 1024
+
+// monitor is a client streaming the commands processed by the store. Lines
+// are queued in its output buffer and written by its own goroutine.
+type monitor struct {
+	out         chan string
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeReason string
+}
+
+func (m *monitor) close(reason string) {
+	m.closeOnce.Do(func() {
+		m.closeReason = reason
+		close(m.closed)
+	})
+}
+
+type monitorHub struct {
+	lock     sync.RWMutex
+	monitors map[*monitor]bool
+	// count is the number of monitors, read atomically so that commands
+	// skip the hub when nobody is monitoring
+	count int32
+}
+
+func newMonitorHub() *monitorHub {
+	return &monitorHub{monitors: make(map[*monitor]bool)}
+}
+
+func (hub *monitorHub) add() *monitor {
+	m := &monitor{
+		out:    make(chan string, monitorBufferLines),
+		closed: make(chan struct{}),
+	}
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	hub.monitors[m] = true
+	atomic.AddInt32(&hub.count, 1)
+	return m
+}
+
+func (hub *monitorHub) remove(m *monitor) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if hub.monitors[m] {
+		delete(hub.monitors, m)
+		atomic.AddInt32(&hub.count, -1)
+	}
+	m.close("client closed")
+}
+
+// feed sends cmd, run by c, to the monitors. A monitor falling behind is
+// disconnected rather than slowing down the commands.
+func (hub *monitorHub) feed(c *client, cmd *command) {
+	if atomic.LoadInt32(&hub.count) == 0 {
+		return
+	}
+	line := monitorLine(time.Now(), c.db, c.addr, cmd)
+
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+	for m := range hub.monitors {
+		select {
+		case m.out <- line:
+		default:
+			m.close("output buffer limit reached")
+		}
+	}
+}
+
+// monitorLine formats cmd like Redis MONITOR does:
+// 1339518083.107412 [0 127.0.0.1:60866] "SET" "key" "value"
+func monitorLine(now time.Time, db int, addr string, cmd *command) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, db, addr)
+	for _, arg := range append([]string{cmd.Name}, cmd.Args...) {
+		sb.WriteString(" ")
+		sb.WriteString(quoteArg(arg))
+	}
+	return sb.String()
+}
+
+// quoteArg quotes arg like Redis does, escaping the quotes, backslashes and
+// non printable bytes, so that a line never contains a line break
+func quoteArg(arg string) string {
+	var sb strings.Builder
+	sb.WriteString(`"`)
+	for i := 0; i < len(arg); i++ {
+		switch b := arg[i]; b {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		default:
+			if b < ' ' || b > '~' {
+				sb.WriteString(`\x` + strconv.FormatUint(uint64(b)|0x100, 16)[1:])
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteString(`"`)
+	return sb.String()
+}
+
+// stream writes the lines of m with write, flushing them, until the client
+// goes away or m is disconnected
+func (m *monitor) stream(r *http.Request, flusher http.Flusher, write func(line string), disconnect func(reason string)) {
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-m.closed:
+			disconnect(m.closeReason)
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			write("")
+			flusher.Flush()
+		case line := <-m.out:
+			write(line)
+			// send whatever else is already queued before flushing
+			for len(m.out) > 0 {
+				write(<-m.out)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// monitor serves the MONITOR command, alone in its request: the reply is OK
+// followed by a line per command processed, RESP encoded as status replies
+// for RESP requests
+func (h *LedisHandler) monitor(w http.ResponseWriter, r *http.Request, format replyFormat) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	hub := h.store.monitors
+	m := hub.add()
+	defer hub.remove(m)
+
+	encode := func(rep reply) string {
+		return rep.encodeText() + "\r\n"
+	}
+	if format == formatRESP {
+		w.Header().Set("Content-Type", respContentType)
+		encode = reply.encodeRESP
+	}
+	io.WriteString(w, encode(statusReply("OK")))
+	flusher.Flush()
+
+	m.stream(r, flusher, func(line string) {
+		if line != "" {
+			io.WriteString(w, encode(statusReply(line)))
+		}
+	}, func(reason string) {
+		io.WriteString(w, encode(errorReply(fmt.Errorf("monitor disconnected: %s", reason))))
+	})
+}
+
+// MonitorHandler streams the commands processed by its store with
+// Server-Sent Events, one monitor event per command
+type MonitorHandler struct {
+	store *LedisStore
+}
+
+func NewMonitorHandler(store *LedisStore) *MonitorHandler {
+	return &MonitorHandler{store: store}
+}
+
+func (h *MonitorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	defer h.store.stats.connect()()
+	hub := h.store.monitors
+	m := hub.add()
+	defer hub.remove(m)
+
+	setHTTPStatus(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	m.stream(r, flusher, func(line string) {
+		if line == "" {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return
+		}
+		fmt.Fprintf(w, "event: monitor\ndata: %s\n\n", line)
+	}, func(reason string) {
+		fmt.Fprintf(w, "event: disconnect\ndata: %s\n\n", reason)
+	})
+}
@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// readLines sends the lines read from r on the returned channel
+func readLines(r *bufio.Reader) <-chan string {
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}
+
+func TestMonitor(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", handlers.NewLedisHandler(store))
+	mux.Handle("/monitor", handlers.NewMonitorHandler(store))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	// Server-Sent Events
+	resp, err := http.Get(server.URL + "/monitor")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+	events := readLines(bufio.NewReader(resp.Body))
+
+	// MONITOR over RESP replies OK, then a status reply per command
+	body := bytes.NewBufferString("*1\r\n$7\r\nMONITOR\r\n")
+	monitorResp, err := http.Post(server.URL, "application/x-resp", body)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer monitorResp.Body.Close()
+	respLines := readLines(bufio.NewReader(monitorResp.Body))
+	g.Eventually(respLines).Should(Receive(Equal("+OK\r\n")))
+
+	g.Expect(SendRESP([]string{"SET", "key", "a\nb \"c\""})).To(Equal("+OK\r\n"))
+	g.Expect(SendDBCommand("3", `GET key`)).To(Equal("key not found"))
+	g.Expect(SendRESP([]string{"SET", "bin", "\x00\xff"})).To(Equal("+OK\r\n"))
+	g.Expect(SendCommand("MONITOR\nGET missing")).To(Equal(`["ERROR: MONITOR must be the only command of its request","key not found"]` + "\n"))
+	g.Expect(SendCommand(`MONITOR now`)).To(Equal("ERROR: MONITOR expects no argument"))
+
+	expected := []string{
+		`^\d{10}\.\d{6} \[0 127\.0\.0\.1:\d+\] "SET" "key" "a\\nb \\"c\\""$`,
+		`^\d{10}\.\d{6} \[3 127\.0\.0\.1:\d+\] "GET" "key"$`,
+		`^\d{10}\.\d{6} \[0 127\.0\.0\.1:\d+\] "SET" "bin" "\\x00\\xff"$`,
+		`^\d{10}\.\d{6} \[0 127\.0\.0\.1:\d+\] "MONITOR"$`,
+		`^\d{10}\.\d{6} \[0 127\.0\.0\.1:\d+\] "GET" "missing"$`,
+		`^\d{10}\.\d{6} \[0 127\.0\.0\.1:\d+\] "MONITOR" "now"$`,
+	}
+	for _, line := range expected {
+		g.Eventually(events).Should(Receive(Equal("event: monitor\n")))
+		g.Eventually(events).Should(Receive(MatchRegexp(`^data: ` + line[1:len(line)-1] + "\n$")))
+		g.Eventually(events).Should(Receive(Equal("\n")))
+		g.Eventually(respLines).Should(Receive(MatchRegexp(`^\+` + line[1:len(line)-1] + "\r\n$")))
+	}
+	g.Consistently(respLines).ShouldNot(Receive(), "Monitors see no other command")
+}
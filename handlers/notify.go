@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// keyspace event classes, selected with the notify-keyspace-events setting
+const (
+	notifyKeyspace = 1 << iota // K: published to __keyspace@<db>__:<key>
+	notifyKeyevent             // E: published to __keyevent@<db>__:<event>
+	notifyGeneric              // g: generic commands like DEL and EXPIRE
+	notifyString               // $: string commands
+	notifyList                 // l: list commands
+	notifySet                  // s: set commands
+	notifyExpired              // x: keys removed when they expire
+	notifyEvicted              // e: keys evicted
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyExpired | notifyEvicted
+)
+
+var notifyFlagChars = []struct {
+	char byte
+	flag int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+// parseNotifyFlags parses a notify-keyspace-events value like "KEA" or "Ex"
+func parseNotifyFlags(value string) (int, error) {
+	flags := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, fc := range notifyFlagChars {
+			if fc.char == value[i] {
+				flags |= fc.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown keyspace event class '%c'", value[i])
+		}
+	}
+	return flags, nil
+}
+
+func notifyFlagsString(flags int) string {
+	res := ""
+	if flags&notifyAll == notifyAll {
+		res = "A"
+	}
+	for _, fc := range notifyFlagChars {
+		if fc.flag&notifyAll != 0 && res == "A" {
+			continue
+		}
+		if flags&fc.flag != 0 {
+			res += string(fc.char)
+		}
+	}
+	return res
+}
+
+// notify publishes the keyspace event fired by a change of key, when its
+// class is enabled. It must be called with the shard lock of key held, so
+// that the events of a key are published in order.
+func (db *ledisDB) notify(class int, event, key string) {
+	// every change fires an event, and counts for the next SAVE
+	atomic.AddInt64(&db.stats.dirty, 1)
+
+	flags := int(atomic.LoadInt32(&db.notifyFlags))
+	if flags&class == 0 || flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return
+	}
+
+	index := strconv.Itoa(db.index)
+	if flags&notifyKeyspace != 0 {
+		db.pubsub.publish("__keyspace@"+index+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		db.pubsub.publish("__keyevent@"+index+"__:"+event, key)
+	}
+}
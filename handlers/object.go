@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// objectCommand parses OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key
+func objectCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) != 2 {
+		return errorReply(fmt.Errorf("OBJECT expects 2 arguments"))
+	}
+
+	subcommand := strings.ToUpper(cmd.Args[0])
+	switch subcommand {
+	case "ENCODING", "IDLETIME", "FREQ", "REFCOUNT":
+		return db.Object(subcommand, cmd.Args[1])
+	default:
+		return errorReply(fmt.Errorf("unknown OBJECT subcommand: %s", cmd.Args[0]))
+	}
+}
+
+// Object returns the internal representation of the value at key, or the
+// access metadata of the key: the seconds since its last access and its LFU
+// counter. Inspecting a key does not count as an access.
+func (db *ledisDB) Object(subcommand, key string) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+
+	val, ok := db.peek(key)
+	if !ok {
+		return nilReply("key not found")
+	}
+	switch subcommand {
+	case "ENCODING":
+		return bulkReply(encodingName(val))
+	case "IDLETIME":
+		return intReply(int(val.meta.idle() / time.Second))
+	case "FREQ":
+		return intReply(int(val.meta.frequency(time.Now().UnixNano())))
+	default:
+		if val.DataType == TypeString && shared(val.StringData) {
+			// like Redis, shared values are never freed
+			return intReply(math.MaxInt32)
+		}
+		return intReply(1)
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// a subscriber is disconnected as soon as it has this many messages
+	// waiting to be written, or this many bytes of payloads
+	pubsubBufferMessages = 1024
+	pubsubBufferBytes    = 32 * 1024 * 1024
+
+	subscriberHeader  = "X-Ledis-Subscriber"
+	keepAliveInterval = 15 * time.Second
+)
+
+// pubsubMessage is either a message published to a channel, or the
+// confirmation of a (un)subscription, which carries the subscription count
+type pubsubMessage struct {
+	kind    string
+	pattern string
+	channel string
+	data    string
+	count   int
+}
+
+func (msg *pubsubMessage) size() int64 {
+	return int64(len(msg.pattern) + len(msg.channel) + len(msg.data))
+}
+
+// encode returns the message laid out like the arrays Redis pushes to its
+// subscribers, e.g. ["pmessage", pattern, channel, data]
+func (msg *pubsubMessage) encode() []byte {
+	var fields []interface{}
+	switch msg.kind {
+	case "message":
+		fields = []interface{}{msg.kind, msg.channel, msg.data}
+	case "pmessage":
+		fields = []interface{}{msg.kind, msg.pattern, msg.channel, msg.data}
+	case "psubscribe", "punsubscribe":
+		fields = []interface{}{msg.kind, msg.pattern, msg.count}
+	default:
+		fields = []interface{}{msg.kind, msg.channel, msg.count}
+	}
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+// subscriber is a client connected to the subscribe endpoint. Messages are
+// queued in its output buffer and written to the stream by its own goroutine.
+type subscriber struct {
+	id       string
+	addr     string
+	channels map[string]bool
+	patterns map[string]bool
+
+	out          chan pubsubMessage
+	pendingBytes int64
+	closed       chan struct{}
+	closeOnce    sync.Once
+	closeReason  string
+}
+
+// push queues msg without blocking, it reports false when the subscriber
+// output buffer limits are exceeded
+func (sub *subscriber) push(msg pubsubMessage) bool {
+	size := msg.size()
+	if atomic.AddInt64(&sub.pendingBytes, size) > pubsubBufferBytes {
+		return false
+	}
+	select {
+	case sub.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sub *subscriber) close(reason string) {
+	sub.closeOnce.Do(func() {
+		sub.closeReason = reason
+		close(sub.closed)
+	})
+}
+
+func (sub *subscriber) subscriptionCount() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+type pubsubHub struct {
+	lock        sync.RWMutex
+	subscribers map[string]*subscriber
+	channels    map[string]map[*subscriber]bool
+	patterns    map[string]map[*subscriber]bool
+}
+
+func newPubsubHub() *pubsubHub {
+	return &pubsubHub{
+		subscribers: make(map[string]*subscriber),
+		channels:    make(map[string]map[*subscriber]bool),
+		patterns:    make(map[string]map[*subscriber]bool),
+	}
+}
+
+func (hub *pubsubHub) newSubscriber(addr string) *subscriber {
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+	sub := &subscriber{
+		id:       hex.EncodeToString(idBytes),
+		addr:     addr,
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+		out:      make(chan pubsubMessage, pubsubBufferMessages),
+		closed:   make(chan struct{}),
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	hub.subscribers[sub.id] = sub
+	return sub
+}
+
+func (hub *pubsubHub) lookup(id string) (*subscriber, bool) {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+
+	sub, ok := hub.subscribers[id]
+	return sub, ok
+}
+
+// removeSubscriber drops all the subscriptions of sub and closes it
+func (hub *pubsubHub) removeSubscriber(sub *subscriber, reason string) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	for channel := range sub.channels {
+		hub.unlink(hub.channels, channel, sub)
+	}
+	for pattern := range sub.patterns {
+		hub.unlink(hub.patterns, pattern, sub)
+	}
+	delete(hub.subscribers, sub.id)
+	sub.close(reason)
+}
+
+func (hub *pubsubHub) unlink(subs map[string]map[*subscriber]bool, name string, sub *subscriber) {
+	delete(subs[name], sub)
+	if len(subs[name]) == 0 {
+		delete(subs, name)
+	}
+}
+
+// subscribe adds the channels (or patterns) to the subscriptions of sub and
+// returns the confirmations, which are also sent on the subscriber stream
+func (hub *pubsubHub) subscribe(sub *subscriber, names []string, pattern bool) reply {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	kind, subs, own := "subscribe", hub.channels, sub.channels
+	if pattern {
+		kind, subs, own = "psubscribe", hub.patterns, sub.patterns
+	}
+
+	confirms := []reply{}
+	for _, name := range names {
+		if !own[name] {
+			own[name] = true
+			if subs[name] == nil {
+				subs[name] = make(map[*subscriber]bool)
+			}
+			subs[name][sub] = true
+		}
+		confirms = append(confirms, hub.confirm(sub, kind, name))
+	}
+	return arrayReply(confirms)
+}
+
+// unsubscribe removes the given channels (or patterns) from the
+// subscriptions of sub, all of them when names is empty
+func (hub *pubsubHub) unsubscribe(sub *subscriber, names []string, pattern bool) reply {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	kind, subs, own := "unsubscribe", hub.channels, sub.channels
+	if pattern {
+		kind, subs, own = "punsubscribe", hub.patterns, sub.patterns
+	}
+
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return arrayReply([]reply{arrayReply([]reply{
+				bulkReply(kind), nilReply(""), intReply(sub.subscriptionCount())})})
+		}
+	}
+
+	confirms := []reply{}
+	for _, name := range names {
+		if own[name] {
+			delete(own, name)
+			hub.unlink(subs, name, sub)
+		}
+		confirms = append(confirms, hub.confirm(sub, kind, name))
+	}
+	return arrayReply(confirms)
+}
+
+func (hub *pubsubHub) confirm(sub *subscriber, kind, name string) reply {
+	count := sub.subscriptionCount()
+	msg := pubsubMessage{kind: kind, count: count}
+	if kind == "psubscribe" || kind == "punsubscribe" {
+		msg.pattern = name
+	} else {
+		msg.channel = name
+	}
+	if !sub.push(msg) {
+		go hub.removeSubscriber(sub, "output buffer limit reached")
+	}
+	return arrayReply([]reply{bulkReply(kind), bulkReply(name), intReply(count)})
+}
+
+// publish sends message to the subscribers of channel and to the
+// subscribers of the patterns matching channel. It never blocks: slow
+// subscribers exceeding their output buffer limits are disconnected.
+func (hub *pubsubHub) publish(channel, message string) int {
+	hub.lock.RLock()
+	receivers := 0
+	slow := []*subscriber{}
+	for sub := range hub.channels[channel] {
+		receivers++
+		if !sub.push(pubsubMessage{kind: "message", channel: channel, data: message}) {
+			slow = append(slow, sub)
+		}
+	}
+	for pattern, subs := range hub.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			receivers++
+			if !sub.push(pubsubMessage{kind: "pmessage", pattern: pattern, channel: channel, data: message}) {
+				slow = append(slow, sub)
+			}
+		}
+	}
+	hub.lock.RUnlock()
+
+	for _, sub := range slow {
+		hub.removeSubscriber(sub, "output buffer limit reached")
+	}
+	return receivers
+}
+
+// activeChannels returns the channels having at least one subscriber and
+// matching pattern, every channel when pattern is empty
+func (hub *pubsubHub) activeChannels(pattern string) []string {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+
+	channels := []string{}
+	for channel := range hub.channels {
+		if pattern == "" || globMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (hub *pubsubHub) numSub(channel string) int {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+
+	return len(hub.channels[channel])
+}
+
+func (hub *pubsubHub) numPat() int {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+
+	return len(hub.patterns)
+}
+
+func pubsubCommand(c *client, name string, cmd *command) reply {
+	hub := c.store.pubsub
+
+	switch name {
+	case "PUBLISH":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("PUBLISH expects 2 arguments"))
+		}
+		return intReply(hub.publish(cmd.Args[0], cmd.Args[1]))
+	case "PUBSUB":
+		if len(cmd.Args) < 1 {
+			return errorReply(fmt.Errorf("PUBSUB expects at least 1 argument"))
+		}
+		return pubsubInfo(hub, cmd)
+	}
+
+	if c.subscriber == "" {
+		return errorReply(fmt.Errorf("%s requires a subscriber, open a stream at /subscribe and send its id in the %s header", name, subscriberHeader))
+	}
+	sub, ok := hub.lookup(c.subscriber)
+	if !ok {
+		return errorReply(fmt.Errorf("unknown subscriber: %s", c.subscriber))
+	}
+
+	switch name {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(cmd.Args) < 1 {
+			return errorReply(fmt.Errorf("%s expects at least 1 argument", name))
+		}
+		return hub.subscribe(sub, cmd.Args, name == "PSUBSCRIBE")
+	default:
+		return hub.unsubscribe(sub, cmd.Args, name == "PUNSUBSCRIBE")
+	}
+}
+
+func pubsubInfo(hub *pubsubHub, cmd *command) reply {
+	subcommand := cmd.Args[0]
+	switch {
+	case strings.EqualFold(subcommand, "CHANNELS"):
+		if len(cmd.Args) > 2 {
+			return errorReply(fmt.Errorf("PUBSUB CHANNELS expects at most 1 pattern"))
+		}
+		pattern := ""
+		if len(cmd.Args) == 2 {
+			pattern = cmd.Args[1]
+		}
+		return bulkArrayReply(hub.activeChannels(pattern))
+	case strings.EqualFold(subcommand, "NUMSUB"):
+		counts := []reply{}
+		for _, channel := range cmd.Args[1:] {
+			counts = append(counts, bulkReply(channel), intReply(hub.numSub(channel)))
+		}
+		return arrayReply(counts)
+	case strings.EqualFold(subcommand, "NUMPAT"):
+		return intReply(hub.numPat())
+	default:
+		return errorReply(fmt.Errorf("unknown PUBSUB subcommand: %s", subcommand))
+	}
+}
+
+// SubscribeHandler streams pubsub messages to a subscriber with Server-Sent
+// Events. Channels and patterns to subscribe to at connection are given with
+// the channel and pattern query parameters, the first event carries the
+// subscriber id used to (un)subscribe later with commands.
+type SubscribeHandler struct {
+	store *LedisStore
+}
+
+func NewSubscribeHandler(store *LedisStore) *SubscribeHandler {
+	return &SubscribeHandler{store: store}
+}
+
+func (h *SubscribeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	defer h.store.stats.connect()()
+	hub := h.store.pubsub
+	sub := hub.newSubscriber(r.RemoteAddr)
+	defer hub.removeSubscriber(sub, "client closed")
+
+	setHTTPStatus(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "event: subscriber\ndata: %s\n\n", sub.id)
+
+	query := r.URL.Query()
+	if channels := query["channel"]; len(channels) > 0 {
+		hub.subscribe(sub, channels, false)
+	}
+	if patterns := query["pattern"]; len(patterns) > 0 {
+		hub.subscribe(sub, patterns, true)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.closed:
+			fmt.Fprintf(w, "event: disconnect\ndata: %s\n\n", sub.closeReason)
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case msg := <-sub.out:
+			writeEvent(w, sub, msg)
+			// send whatever else is already queued before flushing
+			for len(sub.out) > 0 {
+				writeEvent(w, sub, <-sub.out)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, sub *subscriber, msg pubsubMessage) {
+	atomic.AddInt64(&sub.pendingBytes, -msg.size())
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.kind, msg.encode())
+}
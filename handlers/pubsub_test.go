@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// Subscribe opens a subscribe stream and returns its subscriber id, the
+// received events are sent on the returned channel
+func Subscribe(g *GomegaWithT, url string) (string, <-chan sseEvent, func()) {
+	resp, err := http.Get(url)
+	g.Expect(err).To(BeNil())
+	g.Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(resp.Body)
+		event := sseEvent{}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+
+	first := <-events
+	g.Expect(first.name).To(Equal("subscriber"))
+	return first.data, events, func() { resp.Body.Close() }
+}
+
+func SendSubscriberCommand(subscriber, cmd string) string {
+	_, body, errs := gorequest.New().Post(serverUrl).Type("text").
+		Set("X-Ledis-Subscriber", subscriber).SendString(cmd).End()
+	if errs != nil {
+		panic(errs)
+	}
+	return body
+}
+
+func TestPubsub(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", handlers.NewLedisHandler(store))
+	mux.Handle("/subscribe", handlers.NewSubscribeHandler(store))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	g.Expect(SendCommand(`PUBLISH news hello`)).To(Equal("0"), "No subscriber yet")
+
+	id1, events1, close1 := Subscribe(g, server.URL+"/subscribe?channel=news&channel=sport")
+	g.Expect(<-events1).To(Equal(sseEvent{"subscribe", `["subscribe","news",1]`}))
+	g.Expect(<-events1).To(Equal(sseEvent{"subscribe", `["subscribe","sport",2]`}))
+
+	id2, events2, close2 := Subscribe(g, server.URL+"/subscribe?pattern=n[aeiou]ws*")
+	defer close2()
+	g.Expect(<-events2).To(Equal(sseEvent{"psubscribe", `["psubscribe","n[aeiou]ws*",1]`}))
+	g.Expect(id2).NotTo(Equal(id1))
+
+	g.Expect(SendCommand(`PUBLISH news "hello world"`)).To(Equal("2"))
+	g.Expect(<-events1).To(Equal(sseEvent{"message", `["message","news","hello world"]`}))
+	g.Expect(<-events2).To(Equal(sseEvent{"pmessage", `["pmessage","n[aeiou]ws*","news","hello world"]`}))
+	g.Expect(SendCommand(`PUBLISH newsroom ""`)).To(Equal("1"))
+	g.Expect(<-events2).To(Equal(sseEvent{"pmessage", `["pmessage","n[aeiou]ws*","newsroom",""]`}))
+	g.Expect(SendCommand(`PUBLISH nxws x`)).To(Equal("0"))
+
+	// subscriptions are changed with commands carrying the subscriber id
+	g.Expect(SendSubscriberCommand(id2, `SUBSCRIBE weather`)).To(Equal("subscribe\r\nweather\r\n2\r\n\r\n"))
+	g.Expect(<-events2).To(Equal(sseEvent{"subscribe", `["subscribe","weather",2]`}))
+	g.Expect(SendCommand(`PUBSUB CHANNELS`)).To(Equal("news\r\nsport\r\nweather\r\n"))
+	g.Expect(SendCommand(`PUBSUB CHANNELS s*`)).To(Equal("sport\r\n"))
+	g.Expect(SendCommand(`PUBSUB NUMSUB news weather none`)).To(Equal("news\r\n1\r\nweather\r\n1\r\nnone\r\n0\r\n"))
+	g.Expect(SendCommand(`PUBSUB NUMPAT`)).To(Equal("1"))
+
+	g.Expect(SendSubscriberCommand(id1, `UNSUBSCRIBE news`)).To(Equal("unsubscribe\r\nnews\r\n1\r\n\r\n"))
+	g.Expect(<-events1).To(Equal(sseEvent{"unsubscribe", `["unsubscribe","news",1]`}))
+	g.Expect(SendSubscriberCommand(id2, `PUNSUBSCRIBE`)).To(Equal("punsubscribe\r\nn[aeiou]ws*\r\n1\r\n\r\n"))
+	g.Expect(<-events2).To(Equal(sseEvent{"punsubscribe", `["punsubscribe","n[aeiou]ws*",1]`}))
+	g.Expect(SendCommand(`PUBLISH news hello`)).To(Equal("0"))
+
+	// closing the stream drops all the subscriptions
+	close1()
+	g.Eventually(func() string { return SendCommand(`PUBSUB CHANNELS`) }).Should(Equal("weather\r\n"))
+	g.Expect(SendSubscriberCommand(id1, `SUBSCRIBE news`)).To(Equal("ERROR: unknown subscriber: " + id1))
+
+	tests := []ValidateExactTest{
+		{`SUBSCRIBE news`, "ERROR: SUBSCRIBE requires a subscriber, open a stream at /subscribe and send its id in the X-Ledis-Subscriber header", ""},
+		{`PUBLISH news`, "ERROR: PUBLISH expects 2 arguments", ""},
+		{`PUBSUB`, "ERROR: PUBSUB expects at least 1 argument", ""},
+		{`PUBSUB SOMETHING`, "ERROR: unknown PUBSUB subcommand: SOMETHING", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", handlers.NewLedisHandler(store))
+	mux.Handle("/subscribe", handlers.NewSubscribeHandler(store))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	g.Expect(SendCommand(`CONFIG GET notify-keyspace-events`)).To(Equal("notify-keyspace-events\r\n\r\n"), "Disabled by default")
+
+	_, events, closeStream := Subscribe(g, server.URL+"/subscribe?pattern=__key*@0__:*")
+	defer closeStream()
+	<-events
+
+	SendCommand(`SET session:1 abc`)
+	g.Expect(SendCommand(`CONFIG SET notify-keyspace-events KEl$x`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG GET notify-*`)).To(Equal("notify-keyspace-events\r\n$lxKE\r\n"))
+
+	SendCommand(`SET session:1 abc`)
+	g.Expect(<-events).To(Equal(sseEvent{"pmessage", `["pmessage","__key*@0__:*","__keyspace@0__:session:1","set"]`}))
+	g.Expect(<-events).To(Equal(sseEvent{"pmessage", `["pmessage","__key*@0__:*","__keyevent@0__:set","session:1"]`}))
+
+	// generic and set events are not enabled
+	SendCommand("SADD tags a\nDEL tags\nRPUSH queue job1\nLPOP queue")
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyspace@0__:queue","rpush"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:rpush","queue"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyspace@0__:queue","lpop"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:lpop","queue"`))
+
+	g.Expect(SendCommand(`CONFIG SET notify-keyspace-events Eg`)).To(Equal("OK"))
+	SendCommand("EXPIRE session:1 2\nSADD tags a b\nSREM tags a\nDEL tags")
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:expire","session:1"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:del","tags"`))
+
+	g.Expect(SendCommand(`CONFIG SET notify-keyspace-events KA`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG GET notify-keyspace-events`)).To(Equal("notify-keyspace-events\r\nAK\r\n"))
+	g.Eventually(events, 5*time.Second).Should(Receive(Equal(
+		sseEvent{"pmessage", `["pmessage","__key*@0__:*","__keyspace@0__:session:1","expired"]`})), "Test expired event")
+
+	// the set commands storing their result
+	g.Expect(SendCommand(`CONFIG SET notify-keyspace-events Es`)).To(Equal("OK"))
+	SendCommand("SADD a x\nSADD b x\nSINTERSTORE dest a b\nSUNIONSTORE dest a b\nSDIFFSTORE other a c")
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sadd","a"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sadd","b"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sinterstore","dest"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sunionstore","dest"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sdiffstore","other"`))
+
+	// SMOVE to a set already holding the member only removes it
+	SendCommand("SMOVE a b x\nSADD c y")
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:srem","a"`))
+	g.Expect((<-events).data).To(ContainSubstring(`"__keyevent@0__:sadd","c"`))
+
+	tests := []ValidateExactTest{
+		{`CONFIG SET notify-keyspace-events Kq`, "ERROR: invalid argument 'Kq' for CONFIG SET 'notify-keyspace-events': unknown keyspace event class 'q'", ""},
+		{`CONFIG SET maxclients 10`, "ERROR: unsupported CONFIG parameter: maxclients", ""},
+		{`CONFIG GET`, "ERROR: CONFIG GET expects 1 argument", ""},
+		{`CONFIG SET a`, "ERROR: CONFIG SET expects 2 arguments", ""},
+		{`CONFIG`, "ERROR: CONFIG expects at least 1 argument", ""},
+		{`CONFIG RESETSTAT`, "ERROR: unknown CONFIG subcommand: RESETSTAT", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
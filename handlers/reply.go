@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type replyKind int
+
+const (
+	replyStatus replyKind = iota
+	replyError
+	replyInteger
+	replyBulk
+	replyNil
+	replyArray
+)
+
+const wrongTypeMsg = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// reply is the result of a command, independent of the wire encoding.
+// Values are kept as raw byte strings so that replies are binary safe.
+type reply struct {
+	kind  replyKind
+	str   string
+	num   int64
+	elems []reply
+
+	// text overrides how the reply is rendered for the plain text
+	// protocol, which historically differs from Redis for missing keys,
+	// nil values and empty collections
+	text string
+}
+
+func statusReply(status string) reply {
+	return reply{kind: replyStatus, str: status}
+}
+
+// errorReply builds a generic ERR reply from err
+func errorReply(err error) reply {
+	return reply{kind: replyError, str: "ERR " + err.Error()}
+}
+
+func wrongTypeReply() reply {
+	return reply{kind: replyError, str: wrongTypeMsg}
+}
+
+func intReply(num int) reply {
+	return reply{kind: replyInteger, num: int64(num)}
+}
+
+func bulkReply(val string) reply {
+	return reply{kind: replyBulk, str: val}
+}
+
+func nilReply(text string) reply {
+	return reply{kind: replyNil, text: text}
+}
+
+func arrayReply(elems []reply) reply {
+	return reply{kind: replyArray, elems: elems}
+}
+
+func bulkArrayReply(vals []string) reply {
+	elems := make([]reply, 0, len(vals))
+	for _, val := range vals {
+		elems = append(elems, bulkReply(val))
+	}
+	return arrayReply(elems)
+}
+
+func (r reply) withText(text string) reply {
+	r.text = text
+	return r
+}
+
+// whenEmpty sets the plain text rendering of an empty array reply
+func (r reply) whenEmpty(text string) reply {
+	if len(r.elems) == 0 {
+		r.text = text
+	}
+	return r
+}
+
+// encodeText renders the reply for the plain text protocol: arrays are
+// rendered one element per line, terminated by CRLF.
+func (r reply) encodeText() string {
+	if r.text != "" {
+		return r.text
+	}
+
+	switch r.kind {
+	case replyError:
+		if strings.HasPrefix(r.str, "ERR ") {
+			return "ERROR: " + strings.TrimPrefix(r.str, "ERR ")
+		}
+		return r.str
+	case replyInteger:
+		return strconv.FormatInt(r.num, 10)
+	case replyNil:
+		return "(nil)"
+	case replyArray:
+		if len(r.elems) == 0 {
+			return "(empty list or set)"
+		}
+		var sb strings.Builder
+		for _, elem := range r.elems {
+			sb.WriteString(elem.encodeText())
+			sb.WriteString("\r\n")
+		}
+		return sb.String()
+	default:
+		return r.str
+	}
+}
+
+// encodeRESP renders the reply using the Redis serialization protocol
+func (r reply) encodeRESP() string {
+	var sb strings.Builder
+	r.writeRESP(&sb)
+	return sb.String()
+}
+
+func (r reply) writeRESP(sb *strings.Builder) {
+	switch r.kind {
+	case replyStatus:
+		fmt.Fprintf(sb, "+%s\r\n", r.str)
+	case replyError:
+		fmt.Fprintf(sb, "-%s\r\n", r.str)
+	case replyInteger:
+		fmt.Fprintf(sb, ":%d\r\n", r.num)
+	case replyBulk:
+		fmt.Fprintf(sb, "$%d\r\n%s\r\n", len(r.str), r.str)
+	case replyNil:
+		sb.WriteString("$-1\r\n")
+	case replyArray:
+		fmt.Fprintf(sb, "*%d\r\n", len(r.elems))
+		for _, elem := range r.elems {
+			elem.writeRESP(sb)
+		}
+	}
+}
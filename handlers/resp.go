@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// respContentType marks request bodies carrying RESP encoded commands. The
+// replies to such requests are RESP encoded as well, which makes both
+// arguments and values binary safe.
+const respContentType = "application/x-resp"
+
+// parseRESPCommands decodes a body holding one or more RESP arrays of bulk
+// strings, as sent by Redis clients
+func parseRESPCommands(body []byte) ([]*command, error) {
+	// the buffer holds the whole body, so that the lengths announced by the
+	// headers are checked against the bytes left before allocating
+	reader := bufio.NewReaderSize(bytes.NewReader(body), len(body))
+	cmds := []*command{}
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			break
+		}
+		args, err := readRESPArray(reader)
+		if err != nil {
+			return nil, err
+		}
+		cmd, err := newCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return cmds, nil
+}
+
+func readRESPArray(reader *bufio.Reader) ([]string, error) {
+	count, err := readRESPHeader(reader, '*')
+	if err != nil {
+		return nil, err
+	}
+	if count > reader.Buffered() {
+		return nil, fmt.Errorf("Protocol error: array of %d elements is longer than the request", count)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		size, err := readRESPHeader(reader, '$')
+		if err != nil {
+			return nil, err
+		}
+		if size > reader.Buffered() {
+			return nil, fmt.Errorf("Protocol error: unexpected end of bulk string")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, fmt.Errorf("Protocol error: unexpected end of bulk string")
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("Protocol error: bulk string is not terminated by CRLF")
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readRESPHeader reads a "<prefix><number>\r\n" line and returns the number
+func readRESPHeader(reader *bufio.Reader, prefix byte) (int, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("Protocol error: unexpected end of request")
+	}
+	if len(line) < 4 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, fmt.Errorf("Protocol error: expected '%c', got %q", prefix, line)
+	}
+
+	num, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("Protocol error: invalid length %q", line[1:len(line)-2])
+	}
+	return num, nil
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 10
+
+// scanOptions are the MATCH, COUNT and TYPE options of the SCAN family
+type scanOptions struct {
+	match    string
+	count    int
+	dataType string
+}
+
+// scanCommand parses SCAN, SSCAN, HSCAN and ZSCAN. Scans walk the elements
+// ordered by the 64 bits FNV-1a hash of their name, and the cursor is the
+// hash to resume from. As the order does not depend on the elements present,
+// an element present for the whole scan is returned at least once however
+// the collection changes between the calls. SCAN walks the shards one after
+// another in the order of their index, see Scan, while each SSCAN call is
+// O(n log count) as it walks the whole set.
+func scanCommand(db *ledisDB, name string, cmd *command) reply {
+	args := cmd.Args
+	key := ""
+	if name != "SCAN" {
+		if len(args) < 2 {
+			return errorReply(fmt.Errorf("%s expects at least 2 arguments", name))
+		}
+		key, args = args[0], args[1:]
+	} else if len(args) < 1 {
+		return errorReply(fmt.Errorf("SCAN expects at least 1 argument"))
+	}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return errorReply(fmt.Errorf("invalid cursor"))
+	}
+	opts, err := parseScanOptions(args[1:], name == "SCAN")
+	if err != nil {
+		return errorReply(err)
+	}
+
+	switch name {
+	case "SCAN":
+		return db.Scan(cursor, opts)
+	case "SSCAN":
+		return db.Sscan(key, cursor, opts)
+	default:
+		// there are no hashes nor sorted sets, a missing key is scanned as
+		// an empty collection like in Redis
+		return db.scanMissing(key)
+	}
+}
+
+func parseScanOptions(args []string, allowType bool) (scanOptions, error) {
+	opts := scanOptions{match: "*", count: defaultScanCount}
+	if len(args)%2 != 0 {
+		return opts, fmt.Errorf("syntax error, expects option value pairs")
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "MATCH":
+			opts.match = args[i+1]
+		case option == "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, fmt.Errorf("Error when parsing COUNT")
+			}
+			if count < 1 {
+				return opts, fmt.Errorf("COUNT should be a positive number")
+			}
+			opts.count = count
+		case option == "TYPE" && allowType:
+			dataType := strings.ToLower(args[i+1])
+			switch dataType {
+			case "string", "list", "set", "zset", "hash", "stream":
+			default:
+				return opts, fmt.Errorf("unknown type name: %s", args[i+1])
+			}
+			opts.dataType = dataType
+		default:
+			return opts, fmt.Errorf("unknown scan option: %s", args[i])
+		}
+	}
+	return opts, nil
+}
+
+// Scan returns the next keys from cursor and the cursor to continue from, 0
+// once the scan is complete. MATCH and TYPE filter the keys after they are
+// picked, so a call may return fewer than COUNT keys, even none.
+//
+// The keys of a shard all hash to its index modulo the number of shards, so
+// the cursor also tells the shard to resume from. Each shard is read locked
+// in turn, and its keys are found from the cursor in O(log n) with its scan
+// index: a call is O(COUNT log n) and never locks the whole database.
+func (db *ledisDB) Scan(cursor uint64, opts scanOptions) reply {
+	shards := uint64(len(db.shards))
+	matches := []string{}
+	picked := 0
+	for i := cursor % shards; ; {
+		next, more := db.shards[i].scan(cursor, opts.count-picked, func(key string, val LedisData) {
+			picked++
+			if opts.dataType != "" && typeName(val.DataType) != opts.dataType {
+				return
+			}
+			if globMatch(opts.match, key) {
+				matches = append(matches, key)
+			}
+		})
+		if more {
+			return scanReply(next, matches)
+		}
+		// the smallest hash of the next shard is its index
+		i++
+		if i == shards {
+			return scanReply(0, matches)
+		}
+		cursor = i
+		if picked >= opts.count {
+			return scanReply(cursor, matches)
+		}
+	}
+}
+
+// scan calls fn for count keys of the shard from the hash cursor on, and
+// for the following keys sharing the hash of the last one, so that the
+// next call does not skip them. It returns the hash to resume from, and
+// false once it reached the end of the shard.
+func (s *shard) scan(cursor uint64, count int, fn func(key string, val LedisData)) (uint64, bool) {
+	unlock := acquire(false, []*shard{s})
+	defer unlock()
+
+	node := s.index.seek(cursor)
+	for ; node != nil && count > 0; node = node.next[0] {
+		fn(node.key, s.Data[node.key])
+		count--
+		for node.next[0] != nil && node.next[0].hash == node.hash {
+			node = node.next[0]
+			fn(node.key, s.Data[node.key])
+		}
+	}
+	if node == nil {
+		return 0, false
+	}
+	return node.hash, true
+}
+
+// Sscan iterates the members of the set at key like Scan iterates keys
+func (db *ledisDB) Sscan(key string, cursor uint64, opts scanOptions) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+
+	members, next := scanStep(cursor, opts.count, func(fn func(string)) {
+		set.Each(func(member string) bool {
+			fn(member)
+			return true
+		})
+	})
+
+	matches := make([]string, 0, len(members))
+	for _, member := range members {
+		if globMatch(opts.match, member) {
+			matches = append(matches, member)
+		}
+	}
+	return scanReply(next, matches)
+}
+
+func (db *ledisDB) scanMissing(key string) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+
+	if _, ok := db.lookup(key); ok {
+		return wrongTypeReply()
+	}
+	return scanReply(0, nil)
+}
+
+func scanReply(cursor uint64, items []string) reply {
+	next := strconv.FormatUint(cursor, 10)
+	var sb strings.Builder
+	sb.WriteString(next + "\r\n")
+	for _, item := range items {
+		sb.WriteString(item + "\r\n")
+	}
+	return arrayReply([]reply{bulkReply(next), bulkArrayReply(items)}).withText(sb.String())
+}
+
+// keyHash is the 64 bits FNV-1a hash of a key or member, which orders scans
+// and picks the shard of keys
+func keyHash(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+// scanStep returns the names, given by each, with the count smallest hashes
+// from cursor on, and the cursor following them. Names sharing the last hash
+// are all returned, so that none is skipped by the next step.
+func scanStep(cursor uint64, count int, each func(func(string))) ([]string, uint64) {
+	// first find the count-th smallest hash, keeping the count smallest
+	// hashes in a max heap
+	hashes := &hashHeap{}
+	each(func(name string) {
+		hash := keyHash(name)
+		if hash < cursor {
+			return
+		}
+		if hashes.Len() < count {
+			heap.Push(hashes, hash)
+		} else if hash < (*hashes)[0] {
+			(*hashes)[0] = hash
+			heap.Fix(hashes, 0)
+		}
+	})
+	if hashes.Len() == 0 {
+		return nil, 0
+	}
+
+	last := (*hashes)[0]
+	names := []string{}
+	each(func(name string) {
+		if hash := keyHash(name); hash >= cursor && hash <= last {
+			names = append(names, name)
+		}
+	})
+
+	// the overflow of last+1 to 0 also ends the scan
+	next := last + 1
+	if hashes.Len() < count {
+		next = 0
+	}
+	return names, next
+}
+
+// hashHeap is a max heap of hashes
+type hashHeap []uint64
+
+func (h hashHeap) Len() int            { return len(h) }
+func (h hashHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
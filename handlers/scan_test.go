@@ -0,0 +1,119 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+// ScanAll runs a scan command to completion, format is the command with a
+// %s placeholder for the cursor. between is run after each call.
+func ScanAll(format string, between func()) []string {
+	items := []string{}
+	cursor := "0"
+	for {
+		lines := strings.Split(SendCommand(fmt.Sprintf(format, cursor)), "\r\n")
+		cursor = lines[0]
+		items = append(items, lines[1:len(lines)-1]...)
+		if cursor == "0" {
+			return items
+		}
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	var cmds []string
+	for i := 0; i < 200; i++ {
+		cmds = append(cmds, fmt.Sprintf("SET user:%d %d", i, i))
+	}
+	cmds = append(cmds, "RPUSH queue a", "SADD tags a b", "SADD other x")
+	SendCommand(strings.Join(cmds, "\n"))
+
+	keys := ScanAll("SCAN %s COUNT 7", nil)
+	g.Expect(keys).To(HaveLen(203), "Every key is returned once by a stable scan")
+	g.Expect(keys).To(ContainElement("user:199"))
+	g.Expect(keys).To(ContainElement("queue"))
+
+	g.Expect(ScanAll("SCAN %s", nil)).To(HaveLen(203), "COUNT defaults to 10")
+	g.Expect(ScanAll("SCAN %s COUNT 1000", nil)).To(HaveLen(203))
+
+	// keys present for the whole scan are returned despite writes in between
+	added := 0
+	keys = ScanAll("SCAN %s COUNT 5", func() {
+		SendCommand(fmt.Sprintf("SET new:%d x\nDEL user:%d", added, 100+added))
+		added++
+	})
+	seen := map[string]bool{}
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for i := 0; i < 100; i++ {
+		g.Expect(seen).To(HaveKey(fmt.Sprintf("user:%d", i)))
+	}
+
+	// MATCH and TYPE
+	matched := ScanAll("SCAN %s MATCH user:1? COUNT 20", nil)
+	g.Expect(matched).To(ConsistOf("user:10", "user:11", "user:12", "user:13", "user:14", "user:15", "user:16", "user:17", "user:18", "user:19"))
+	g.Expect(ScanAll("SCAN %s TYPE set COUNT 3", nil)).To(ConsistOf("tags", "other"))
+	g.Expect(ScanAll("SCAN %s type LIST", nil)).To(ConsistOf("queue"))
+	g.Expect(ScanAll("SCAN %s TYPE hash", nil)).To(BeEmpty())
+	g.Expect(ScanAll("SCAN %s MATCH [qt]* TYPE set", nil)).To(ConsistOf("tags"))
+
+	// SSCAN
+	cmds = []string{}
+	for i := 0; i < 50; i++ {
+		cmds = append(cmds, fmt.Sprintf("SADD big m%d", i))
+	}
+	SendCommand(strings.Join(cmds, "\n"))
+	g.Expect(ScanAll("SSCAN big %s COUNT 4", nil)).To(HaveLen(50), "Test SSCAN")
+	g.Expect(ScanAll("SSCAN big %s MATCH m4*", nil)).To(ConsistOf("m4", "m40", "m41", "m42", "m43", "m44", "m45", "m46", "m47", "m48", "m49"))
+	g.Expect(ScanAll("SSCAN no-exist %s", nil)).To(BeEmpty())
+
+	tests := []ValidateExactTest{
+		{`SSCAN queue 0`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`HSCAN no-exist 0`, "0\r\n", "There are no hashes"},
+		{`ZSCAN no-exist 0 MATCH *`, "0\r\n", "There are no sorted sets"},
+		{`HSCAN tags 0`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`ZSCAN user:1 0`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SCAN`, "ERROR: SCAN expects at least 1 argument", ""},
+		{`SSCAN big`, "ERROR: SSCAN expects at least 2 arguments", ""},
+		{`SCAN x`, "ERROR: invalid cursor", ""},
+		{`SCAN -1`, "ERROR: invalid cursor", ""},
+		{`SCAN 0 COUNT`, "ERROR: syntax error, expects option value pairs", ""},
+		{`SCAN 0 COUNT x`, "ERROR: Error when parsing COUNT", ""},
+		{`SCAN 0 COUNT 0`, "ERROR: COUNT should be a positive number", ""},
+		{`SCAN 0 TYPE thing`, "ERROR: unknown type name: thing", ""},
+		{`SSCAN big 0 TYPE set`, "ERROR: unknown scan option: TYPE", ""},
+		{`SCAN 0 LIMIT 1`, "ERROR: unknown scan option: LIMIT", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+
+	g.Expect(SendRESP([]string{"SCAN", "18446744073709551615", "COUNT", "1"})).To(HavePrefix("*2\r\n$1\r\n0\r\n*"))
+
+	// the keys scanned follow SWAPDB and FLUSHDB
+	keys = ScanAll("SCAN %s COUNT 3", nil)
+	g.Expect(SendCommand("SWAPDB 0 1")).To(Equal("OK"))
+	g.Expect(ScanAll("SCAN %s COUNT 3", nil)).To(BeEmpty())
+	g.Expect(SendCommand("SWAPDB 0 1")).To(Equal("OK"))
+	g.Expect(ScanAll("SCAN %s COUNT 3", nil)).To(ConsistOf(keys))
+	g.Expect(SendCommand("FLUSHDB")).To(Equal("OK"))
+	g.Expect(SendCommand("SCAN 0")).To(Equal("0\r\n"))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import "math/rand"
+
+// a skip list of n keys has about log4(n) levels, 16 are enough for
+// billions of keys
+const (
+	scanIndexLevels = 16
+	scanIndexP      = 4
+)
+
+// scanIndex orders the keys of a shard by hash, then by name for the rare
+// keys sharing a hash, so that SCAN resumes from its cursor in O(log n)
+// instead of walking the whole shard. It is a skip list, changed with the
+// shard write lock held like the keys.
+type scanIndex struct {
+	head   scanNode
+	levels int
+	rand   *rand.Rand
+}
+
+type scanNode struct {
+	hash uint64
+	key  string
+	next []*scanNode
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{
+		head:   scanNode{next: make([]*scanNode, scanIndexLevels)},
+		levels: 1,
+		rand:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// before reports whether node comes before the key hashing to hash
+func (node *scanNode) before(hash uint64, key string) bool {
+	return node.hash < hash || node.hash == hash && node.key < key
+}
+
+// path returns, for each level, the last node before the key hashing to
+// hash
+func (idx *scanIndex) path(hash uint64, key string) [scanIndexLevels]*scanNode {
+	var path [scanIndexLevels]*scanNode
+	node := &idx.head
+	for level := idx.levels - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].before(hash, key) {
+			node = node.next[level]
+		}
+		path[level] = node
+	}
+	return path
+}
+
+// insert adds key, which must not be in the index
+func (idx *scanIndex) insert(key string) {
+	hash := keyHash(key)
+	path := idx.path(hash, key)
+	levels := 1
+	for levels < scanIndexLevels && idx.rand.Intn(scanIndexP) == 0 {
+		levels++
+	}
+	for ; idx.levels < levels; idx.levels++ {
+		path[idx.levels] = &idx.head
+	}
+
+	node := &scanNode{hash: hash, key: key, next: make([]*scanNode, levels)}
+	for level := 0; level < levels; level++ {
+		node.next[level] = path[level].next[level]
+		path[level].next[level] = node
+	}
+}
+
+// remove deletes key from the index, if it is there
+func (idx *scanIndex) remove(key string) {
+	hash := keyHash(key)
+	path := idx.path(hash, key)
+	node := path[0].next[0]
+	if node == nil || node.hash != hash || node.key != key {
+		return
+	}
+	for level := range node.next {
+		path[level].next[level] = node.next[level]
+	}
+	for idx.levels > 1 && idx.head.next[idx.levels-1] == nil {
+		idx.levels--
+	}
+}
+
+// seek returns the first node whose hash is at least hash, nil when there
+// is none
+func (idx *scanIndex) seek(hash uint64) *scanNode {
+	return idx.path(hash, "")[0].next[0]
+}
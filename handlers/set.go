@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+func (db *ledisDB) Sadd(key string, values []string) reply {
+	count, err := db.api().SAdd(key, toBytes(values)...)
+	if err != nil {
+		return apiErrorReply(err, reply{})
+	}
+	return intReply(count)
+}
+
+func (db *ledisDB) Scard(key string) reply {
+	count, err := db.api().SCard(key)
+	if err != nil {
+		return apiErrorReply(err, intReply(0).withText("key not found"))
+	}
+	return intReply(count)
+}
+
+func (db *ledisDB) Smembers(key string) reply {
+	members, err := db.api().SMembers(key)
+	if err != nil {
+		return apiErrorReply(err, arrayReply(nil).withText("key not found"))
+	}
+	return bulkArrayReply(toStrings(members)).whenEmpty("(empty set)")
+}
+
+func (db *ledisDB) Srem(key string, values []string) reply {
+	count, err := db.api().SRem(key, toBytes(values)...)
+	if err != nil {
+		return apiErrorReply(err, intReply(0).withText("key not found"))
+	}
+	return intReply(count)
+}
+
+func setCommand(db *ledisDB, name string, cmd *command) reply {
+	switch name {
+	case "SUNION", "SDIFF":
+		if len(cmd.Args) < 1 {
+			return errorReply(fmt.Errorf("%s expects at least 1 argument", name))
+		}
+		if name == "SUNION" {
+			return db.Sunion(cmd.Args)
+		}
+		return db.Sdiff(cmd.Args)
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if len(cmd.Args) < 2 {
+			return errorReply(fmt.Errorf("%s expects at least 2 arguments", name))
+		}
+		op := strings.ToLower(strings.TrimSuffix(name, "STORE"))
+		return db.SetStore(op, cmd.Args[0], cmd.Args[1:])
+	case "SINTERCARD":
+		return sintercardCommand(db, cmd)
+	case "SISMEMBER":
+		if len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("SISMEMBER expects 2 arguments"))
+		}
+		return db.Sismember(cmd.Args[0], cmd.Args[1])
+	case "SMISMEMBER":
+		if len(cmd.Args) < 2 {
+			return errorReply(fmt.Errorf("SMISMEMBER expects at least 2 arguments"))
+		}
+		return db.Smismember(cmd.Args[0], cmd.Args[1:])
+	case "SRANDMEMBER", "SPOP":
+		if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
+			return errorReply(fmt.Errorf("%s expects 1 or 2 arguments", name))
+		}
+		if len(cmd.Args) == 1 {
+			if name == "SPOP" {
+				return db.Spop(cmd.Args[0])
+			}
+			return db.Srandmember(cmd.Args[0])
+		}
+		count, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing count"))
+		}
+		if name == "SPOP" {
+			if count < 0 {
+				return errorReply(fmt.Errorf("Count should not be negative"))
+			}
+			return db.SpopCount(cmd.Args[0], count)
+		}
+		if count < -maxRandomRepeats {
+			return errorReply(fmt.Errorf("value is out of range, must be at least %d", -maxRandomRepeats))
+		}
+		return db.SrandmemberCount(cmd.Args[0], count)
+	default:
+		if len(cmd.Args) != 3 {
+			return errorReply(fmt.Errorf("SMOVE expects 3 arguments"))
+		}
+		return db.Smove(cmd.Args[0], cmd.Args[1], cmd.Args[2])
+	}
+}
+
+// sintercardCommand parses SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercardCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 2 {
+		return errorReply(fmt.Errorf("SINTERCARD expects at least 2 arguments"))
+	}
+	numKeys, err := strconv.Atoi(cmd.Args[0])
+	if err != nil {
+		return errorReply(fmt.Errorf("Error when parsing numkeys"))
+	}
+	if numKeys <= 0 {
+		return errorReply(fmt.Errorf("numkeys should be greater than 0"))
+	}
+	if numKeys > len(cmd.Args)-1 {
+		return errorReply(fmt.Errorf("Number of keys can't be greater than number of args"))
+	}
+
+	keys, rest := cmd.Args[1:1+numKeys], cmd.Args[1+numKeys:]
+	limit := 0
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil {
+			return errorReply(fmt.Errorf("Error when parsing LIMIT"))
+		}
+		if limit < 0 {
+			return errorReply(fmt.Errorf("LIMIT can't be negative"))
+		}
+	default:
+		return errorReply(fmt.Errorf("SINTERCARD expects numkeys keys and an optional LIMIT"))
+	}
+	return db.Sintercard(keys, limit)
+}
+
+func (db *ledisDB) Sinter(keys []string) reply {
+	unlock := db.rlockKeys(keys...)
+	defer unlock()
+	db.countReads(keys...)
+
+	sets, errRep := db.getSets(keys)
+	if errRep != nil {
+		return *errRep
+	}
+	for i, set := range sets {
+		if set == nil {
+			return arrayReply(nil).withText(fmt.Sprintf("key not found: %s", keys[i]))
+		}
+	}
+	return bulkArrayReply(setMembers(setInter(sets, 0))).whenEmpty("empty")
+}
+
+func (db *ledisDB) Sunion(keys []string) reply {
+	unlock := db.rlockKeys(keys...)
+	defer unlock()
+	db.countReads(keys...)
+
+	sets, errRep := db.getSets(keys)
+	if errRep != nil {
+		return *errRep
+	}
+	return bulkArrayReply(setMembers(setUnion(sets))).whenEmpty("empty")
+}
+
+// Sdiff returns the members of the first set that are in none of the others
+func (db *ledisDB) Sdiff(keys []string) reply {
+	unlock := db.rlockKeys(keys...)
+	defer unlock()
+	db.countReads(keys...)
+
+	sets, errRep := db.getSets(keys)
+	if errRep != nil {
+		return *errRep
+	}
+	return bulkArrayReply(setMembers(setDiff(sets))).whenEmpty("empty")
+}
+
+// SetStore computes op ("sinter", "sunion" or "sdiff") over the sets at keys
+// and stores the result at dest, overwriting whatever dest held. An empty
+// result deletes dest. It returns the size of the result.
+func (db *ledisDB) SetStore(op string, dest string, keys []string) reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := db.lockKeys(append([]string{dest}, keys...)...)
+	defer unlock()
+	db.countReads(keys...)
+
+	sets, errRep := db.getSets(keys)
+	if errRep != nil {
+		return *errRep
+	}
+
+	var result map[string]bool
+	switch op {
+	case "sinter":
+		result = setInter(sets, 0)
+	case "sunion":
+		result = setUnion(sets)
+	default:
+		result = setDiff(sets)
+	}
+
+	_, existed := db.lookup(dest)
+	db.persist(dest)
+	if len(result) == 0 {
+		if existed {
+			db.remove(dest)
+			db.notify(notifyGeneric, "del", dest)
+		}
+		return intReply(0)
+	}
+
+	db.put(dest, LedisData{
+		DataType:   TypeSet,
+		SetData:    newLedisSet(setMembers(result)),
+		ListData:   nil,
+		StringData: nil})
+	db.notify(notifySet, op+"store", dest)
+	return intReply(len(result))
+}
+
+// Sintercard returns the size of the intersection of the sets at keys,
+// stopping at limit members when limit is not 0
+func (db *ledisDB) Sintercard(keys []string, limit int) reply {
+	unlock := db.rlockKeys(keys...)
+	defer unlock()
+	db.countReads(keys...)
+
+	sets, errRep := db.getSets(keys)
+	if errRep != nil {
+		return *errRep
+	}
+	return intReply(len(setInter(sets, limit)))
+}
+
+func (db *ledisDB) Sismember(key, member string) reply {
+	isMember, err := db.api().SIsMember(key, []byte(member))
+	if err != nil {
+		return apiErrorReply(err, intReply(0).withText("key not found"))
+	}
+	if isMember {
+		return intReply(1)
+	}
+	return intReply(0)
+}
+
+// Smismember reports, for each member, whether it belongs to the set at key
+func (db *ledisDB) Smismember(key string, members []string) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+	elems := make([]reply, 0, len(members))
+	for _, member := range members {
+		if set.Has(member) {
+			elems = append(elems, intReply(1))
+		} else {
+			elems = append(elems, intReply(0))
+		}
+	}
+	return arrayReply(elems)
+}
+
+// Srandmember returns a random member of the set at key
+func (db *ledisDB) Srandmember(key string) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if set.Len() == 0 {
+		return nilReply("key not found")
+	}
+	return bulkReply(set.Random())
+}
+
+// maxRandomRepeats bounds the number of members SRANDMEMBER returns for a
+// negative count, which does not depend on the size of the set
+const maxRandomRepeats = 1 << 20
+
+// SrandmemberCount returns count distinct random members of the set at key,
+// or the whole set when it is smaller. A negative count returns -count
+// members that may repeat.
+func (db *ledisDB) SrandmemberCount(key string, count int) reply {
+	unlock := db.rlockKeys(key)
+	defer unlock()
+	db.countReads(key)
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if set == nil {
+		return arrayReply(nil).withText("key not found")
+	}
+
+	if count >= 0 {
+		return bulkArrayReply(randomMembers(set, count)).whenEmpty("empty")
+	}
+	members := set.Members()
+	picks := []string{}
+	for i := 0; i < -count; i++ {
+		picks = append(picks, members[rand.Intn(len(members))])
+	}
+	return bulkArrayReply(picks)
+}
+
+// Spop removes and returns a random member of the set at key
+func (db *ledisDB) Spop(key string) reply {
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if set.Len() == 0 {
+		return nilReply("key not found")
+	}
+
+	member := set.Random()
+	set.Remove(member)
+	db.resized(key)
+	db.notify(notifySet, "spop", key)
+	db.deleteIfEmpty(key)
+	return bulkReply(member)
+}
+
+// SpopCount removes and returns up to count random members of the set at key
+func (db *ledisDB) SpopCount(key string, count int) reply {
+	unlock := db.lockKeys(key)
+	defer unlock()
+
+	set, errRep := db.getSet(key)
+	if errRep != nil {
+		return *errRep
+	}
+	if set == nil {
+		return arrayReply(nil).withText("key not found")
+	}
+
+	members := randomMembers(set, count)
+	for _, member := range members {
+		set.Remove(member)
+	}
+	if len(members) > 0 {
+		db.resized(key)
+		db.notify(notifySet, "spop", key)
+		db.deleteIfEmpty(key)
+	}
+	return bulkArrayReply(members).whenEmpty("empty")
+}
+
+// Smove atomically moves member from the set at src to the set at dest,
+// returning 0 when it is not a member of src
+func (db *ledisDB) Smove(src, dest, member string) reply {
+	if errRep := db.growing(); errRep != nil {
+		return *errRep
+	}
+	unlock := db.lockKeys(src, dest)
+	defer unlock()
+
+	srcSet, errRep := db.getSet(src)
+	if errRep != nil {
+		return *errRep
+	}
+	destSet, errRep := db.getSet(dest)
+	if errRep != nil {
+		return *errRep
+	}
+	if !srcSet.Has(member) {
+		return intReply(0)
+	}
+	if src == dest {
+		return intReply(1)
+	}
+
+	srcSet.Remove(member)
+	db.resized(src)
+	db.notify(notifySet, "srem", src)
+	db.deleteIfEmpty(src)
+	if destSet == nil {
+		destSet = newLedisSet(nil)
+		db.put(dest, LedisData{
+			DataType:   TypeSet,
+			SetData:    destSet,
+			ListData:   nil,
+			StringData: nil})
+	}
+	if destSet.Add(member, db.limits()) {
+		db.resized(dest)
+		db.notify(notifySet, "sadd", dest)
+	}
+	return intReply(1)
+}
+
+// getSet returns the set at key, a nil set when the key does not exist, or
+// a WRONGTYPE error reply. The shard lock of key must be held.
+func (db *ledisDB) getSet(key string) (*ledisSet, *reply) {
+	storeVal, ok := db.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if storeVal.DataType != TypeSet {
+		rep := wrongTypeReply()
+		return nil, &rep
+	}
+	return storeVal.SetData, nil
+}
+
+// getSets returns the sets at keys, nil for missing keys. The shard locks
+// of keys must be held.
+func (db *ledisDB) getSets(keys []string) ([]*ledisSet, *reply) {
+	sets := make([]*ledisSet, 0, len(keys))
+	for _, key := range keys {
+		storeVal, ok := db.lookup(key)
+		if !ok {
+			sets = append(sets, nil)
+			continue
+		}
+		if storeVal.DataType != TypeSet {
+			rep := reply{kind: replyError, str: fmt.Sprintf("WRONGTYPE Operation against a key: %s holding the wrong kind of value", key)}
+			return nil, &rep
+		}
+		sets = append(sets, storeVal.SetData)
+	}
+	return sets, nil
+}
+
+// setInter returns a new set with the members common to all sets, a nil set
+// being empty. It stops once the result has limit members, if limit is not 0.
+func setInter(sets []*ledisSet, limit int) map[string]bool {
+	result := make(map[string]bool)
+	// walk the smallest set, checking its members against the others
+	smallest := sets[0]
+	for _, set := range sets[1:] {
+		if set.Len() < smallest.Len() {
+			smallest = set
+		}
+	}
+	smallest.Each(func(member string) bool {
+		for _, set := range sets {
+			if !set.Has(member) {
+				return true
+			}
+		}
+		result[member] = true
+		return len(result) != limit
+	})
+	return result
+}
+
+func setUnion(sets []*ledisSet) map[string]bool {
+	result := make(map[string]bool)
+	for _, set := range sets {
+		set.Each(func(member string) bool {
+			result[member] = true
+			return true
+		})
+	}
+	return result
+}
+
+func setDiff(sets []*ledisSet) map[string]bool {
+	result := make(map[string]bool)
+	sets[0].Each(func(member string) bool {
+		result[member] = true
+		return true
+	})
+	for _, set := range sets[1:] {
+		set.Each(func(member string) bool {
+			delete(result, member)
+			return true
+		})
+	}
+	return result
+}
+
+func setMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	return members
+}
+
+// randomMembers returns count distinct members picked uniformly at random,
+// or all the members when the set is smaller
+func randomMembers(set *ledisSet, count int) []string {
+	if count == 1 && set.Len() > 0 {
+		return []string{set.Random()}
+	}
+	members := set.Members()
+	if count > len(members) {
+		count = len(members)
+	}
+	// partial Fisher-Yates shuffle
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:count]
+}
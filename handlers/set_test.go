@@ -0,0 +1,211 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSetAlgebra(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET testkey 123`, "OK", ""},
+		{`SADD tags:a red green blue`, "3", ""},
+		{`SADD tags:b green blue`, "2", ""},
+		{`SADD tags:c blue yellow`, "2", ""},
+
+		// SINTER leaves its operands untouched
+		{`SINTER tags:a tags:b tags:c`, "blue\r\n", "Test SINTER"},
+		{`SINTER tags:a tags:c`, "blue\r\n", "SINTER does not mutate the first set"},
+		{`SCARD tags:a`, "3", ""},
+
+		// SDIFF
+		{`SDIFF tags:a tags:b`, "red\r\n", "Test SDIFF"},
+		{`SDIFF tags:a tags:b no-exist`, "red\r\n", "Missing keys are empty sets"},
+		{`SDIFF tags:b tags:a`, "empty", ""},
+		{`SDIFF no-exist tags:a`, "empty", ""},
+		{`SDIFF tags:a testkey`, "WRONGTYPE Operation against a key: testkey holding the wrong kind of value", ""},
+
+		// SUNION
+		{`SUNION no-exist`, "empty", "Test SUNION"},
+		{`SUNION tags:a testkey`, "WRONGTYPE Operation against a key: testkey holding the wrong kind of value", ""},
+
+		// *STORE
+		{`SINTERSTORE inter tags:a tags:b`, "2", "Test SINTERSTORE"},
+		{`SCARD inter`, "2", ""},
+		{`SDIFF inter tags:b`, "empty", ""},
+		{`SUNIONSTORE union tags:a tags:b tags:c`, "4", "Test SUNIONSTORE"},
+		{`SDIFF union tags:a tags:c`, "empty", ""},
+		{`SDIFFSTORE diff tags:a tags:b`, "1", "Test SDIFFSTORE"},
+		{`SMEMBERS diff`, "red\r\n", ""},
+		{`EXPIRE testkey 100`, "100", ""},
+		{`SUNIONSTORE testkey tags:c`, "2", "STORE overwrites other types"},
+		{`TTL testkey`, "-1", "and clears the expiration"},
+		{`SINTERSTORE tags:a tags:a tags:c`, "1", "Destination may be an operand"},
+		{`SMEMBERS tags:a`, "blue\r\n", ""},
+		{`SDIFFSTORE diff tags:a tags:c`, "0", "Empty result deletes the destination"},
+		{`SMEMBERS diff`, "key not found", ""},
+		{`SINTERSTORE diff tags:a no-exist`, "0", ""},
+		{`SET str 1`, "OK", ""},
+		{`SUNIONSTORE diff tags:a str`, "WRONGTYPE Operation against a key: str holding the wrong kind of value", ""},
+
+		// SINTERCARD
+		{`SADD big 1 2 3 4 5 6`, "6", ""},
+		{`SADD big2 2 3 4 5 6 7`, "6", ""},
+		{`SINTERCARD 2 big big2`, "5", "Test SINTERCARD"},
+		{`SINTERCARD 2 big big2 LIMIT 3`, "3", ""},
+		{`SINTERCARD 2 big big2 limit 0`, "5", "LIMIT 0 means no limit"},
+		{`SINTERCARD 2 big no-exist`, "0", ""},
+		{`SINTERCARD 1 big`, "6", ""},
+		{`SINTERCARD 2 big str`, "WRONGTYPE Operation against a key: str holding the wrong kind of value", ""},
+	}
+	for _, test := range tests {
+		body := SendCommand(test.command)
+		g.Expect(body).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+
+	// unordered members
+	g.Expect(SendCommand(`SUNION no-exist tags:b tags:c`)).To(SatisfyAll(
+		ContainSubstring("green\r\n"), ContainSubstring("blue\r\n"), ContainSubstring("yellow\r\n"), HaveLen(21)))
+}
+
+func TestSetMembership(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{`SET testkey 123`, "OK", ""},
+		{`SADD colors red green blue`, "3", ""},
+
+		// SISMEMBER, SMISMEMBER
+		{`SISMEMBER colors red`, "1", "Test SISMEMBER"},
+		{`SISMEMBER colors pink`, "0", ""},
+		{`SISMEMBER no-exist red`, "key not found", ""},
+		{`SISMEMBER testkey red`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SMISMEMBER colors red pink blue`, "1\r\n0\r\n1\r\n", "Test SMISMEMBER"},
+		{`SMISMEMBER no-exist red`, "0\r\n", ""},
+		{`SMISMEMBER testkey red`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+
+		// SRANDMEMBER
+		{`SRANDMEMBER no-exist`, "key not found", ""},
+		{`SRANDMEMBER no-exist 2`, "key not found", ""},
+		{`SRANDMEMBER colors 0`, "empty", ""},
+		{`SRANDMEMBER testkey`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SCARD colors`, "3", "SRANDMEMBER does not remove members"},
+		{`SRANDMEMBER colors -9223372036854775808`, "ERROR: value is out of range, must be at least -1048576", ""},
+		{`SRANDMEMBER colors -100000000000`, "ERROR: value is out of range, must be at least -1048576", ""},
+
+		// SPOP
+		{`SADD single only`, "1", ""},
+		{`SPOP single`, "only", "Test SPOP"},
+		{`SMEMBERS single`, "key not found", "The emptied set is deleted"},
+		{`SPOP single`, "key not found", ""},
+		{`SPOP no-exist 2`, "key not found", ""},
+		{`SPOP colors 0`, "empty", ""},
+		{`SPOP testkey`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+
+		// SMOVE
+		{`SADD src a b`, "2", ""},
+		{`SMOVE src dst a`, "1", "Test SMOVE"},
+		{`SISMEMBER src a`, "0", ""},
+		{`SMEMBERS dst`, "a\r\n", "SMOVE creates the destination"},
+		{`SMOVE src dst x`, "0", ""},
+		{`SMOVE no-exist dst a`, "0", ""},
+		{`SMOVE src src b`, "1", ""},
+		{`SMOVE src testkey b`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SMOVE testkey src b`, "WRONGTYPE Operation against a key holding the wrong kind of value", ""},
+		{`SMOVE src dst b`, "1", ""},
+		{`SMEMBERS src`, "key not found", "The emptied source is deleted"},
+		{`SCARD dst`, "2", ""},
+	}
+	for _, test := range tests {
+		body := SendCommand(test.command)
+		g.Expect(body).To(Equal(test.expect), test.testName+": "+test.command)
+	}
+
+	colors := []string{"red", "green", "blue"}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		member := SendCommand(`SRANDMEMBER colors`)
+		g.Expect(colors).To(ContainElement(member))
+		seen[member] = true
+	}
+	g.Expect(seen).To(HaveLen(3), "SRANDMEMBER samples all members")
+
+	var members []string
+	g.Expect(json.Unmarshal([]byte(SendCommand("SRANDMEMBER colors 2\nSRANDMEMBER colors 5\nSRANDMEMBER colors -5")), &members)).To(Succeed())
+	g.Expect(strings.Split(members[0], "\r\n")).To(HaveLen(3))
+	g.Expect(members[1]).To(SatisfyAll(ContainSubstring("red"), ContainSubstring("green"), ContainSubstring("blue")))
+	picks := strings.Split(strings.TrimSuffix(members[2], "\r\n"), "\r\n")
+	g.Expect(picks).To(HaveLen(5), "Negative count allows repeated members")
+	for _, pick := range picks {
+		g.Expect(colors).To(ContainElement(pick))
+	}
+
+	// popping hands out each member exactly once
+	SendCommand(`SADD coupons c1 c2 c3 c4 c5 c6 c7 c8 c9 c10`)
+	popped := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		code := SendCommand(`SPOP coupons`)
+		g.Expect(popped).NotTo(HaveKey(code))
+		popped[code] = true
+	}
+	for _, code := range strings.Split(strings.TrimSuffix(SendCommand(`SPOP coupons 10`), "\r\n"), "\r\n") {
+		g.Expect(popped).NotTo(HaveKey(code))
+		popped[code] = true
+	}
+	g.Expect(popped).To(HaveLen(10))
+	g.Expect(SendCommand(`SCARD coupons`)).To(Equal("key not found"))
+}
+
+func TestInvalidSetCommand(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	handler := handlers.NewLedisHandler(store)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	tests := []ValidateExactTest{
+		{"SUNION", "ERROR: SUNION expects at least 1 argument", ""},
+		{"SDIFF", "ERROR: SDIFF expects at least 1 argument", ""},
+		{"SINTERSTORE dest", "ERROR: SINTERSTORE expects at least 2 arguments", ""},
+		{"SUNIONSTORE dest", "ERROR: SUNIONSTORE expects at least 2 arguments", ""},
+		{"SDIFFSTORE dest", "ERROR: SDIFFSTORE expects at least 2 arguments", ""},
+		{"SINTERCARD 1", "ERROR: SINTERCARD expects at least 2 arguments", ""},
+		{"SINTERCARD a b", "ERROR: Error when parsing numkeys", ""},
+		{"SINTERCARD 0 a", "ERROR: numkeys should be greater than 0", ""},
+		{"SINTERCARD 3 a b", "ERROR: Number of keys can't be greater than number of args", ""},
+		{"SINTERCARD 1 a LIMIT", "ERROR: SINTERCARD expects numkeys keys and an optional LIMIT", ""},
+		{"SINTERCARD 1 a LIMIT x", "ERROR: Error when parsing LIMIT", ""},
+		{"SINTERCARD 1 a LIMIT -1", "ERROR: LIMIT can't be negative", ""},
+		{"SISMEMBER a", "ERROR: SISMEMBER expects 2 arguments", ""},
+		{"SMISMEMBER a", "ERROR: SMISMEMBER expects at least 2 arguments", ""},
+		{"SRANDMEMBER", "ERROR: SRANDMEMBER expects 1 or 2 arguments", ""},
+		{"SRANDMEMBER a x", "ERROR: Error when parsing count", ""},
+		{"SPOP a 1 2", "ERROR: SPOP expects 1 or 2 arguments", ""},
+		{"SPOP a -1", "ERROR: Count should not be negative", ""},
+		{"SMOVE a b", "ERROR: SMOVE expects 3 arguments", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// set encodings, from the most compact one
+const (
+	setIntset = iota
+	setListpack
+	setHashtable
+)
+
+// ledisSet is the representation of sets. Small sets of integers are an
+// intset, a sorted slice of int64, other small sets are packed in a
+// listpack, and large sets are a Go map. A set only ever converts to a less
+// compact encoding: when a member is not an integer, or when it grows over
+// the limits of the set-max-* settings, see encode. A nil set is empty.
+type ledisSet struct {
+	encoding int
+	ints     []int64
+	packed   listpack
+	members  map[string]bool
+	// size is the number of members of the compact encodings
+	size int
+	// bytes is the estimated size of the members of the map
+	bytes int64
+}
+
+// newLedisSet returns a compact set of the distinct members, converted by
+// put if it is too large
+func newLedisSet(members []string) *ledisSet {
+	ints := make([]int64, 0, len(members))
+	for _, member := range members {
+		n, ok := parseInteger(member)
+		if !ok {
+			set := &ledisSet{encoding: setListpack, size: len(members)}
+			for _, member := range members {
+				set.packed = set.packed.append(member)
+			}
+			return set
+		}
+		ints = append(ints, n)
+	}
+	sort.Slice(ints, func(i, j int) bool { return ints[i] < ints[j] })
+	return &ledisSet{encoding: setIntset, ints: ints, size: len(ints)}
+}
+
+// parseInteger parses member if it is the canonical form of an int64, the
+// only members an intset holds
+func parseInteger(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+func (set *ledisSet) Len() int {
+	switch {
+	case set == nil:
+		return 0
+	case set.encoding == setHashtable:
+		return len(set.members)
+	default:
+		return set.size
+	}
+}
+
+// search returns the position of n in the intset, or where to insert it
+func (set *ledisSet) search(n int64) (int, bool) {
+	i := sort.Search(len(set.ints), func(i int) bool { return set.ints[i] >= n })
+	return i, i < len(set.ints) && set.ints[i] == n
+}
+
+func (set *ledisSet) Has(member string) bool {
+	if set == nil {
+		return false
+	}
+	switch set.encoding {
+	case setIntset:
+		n, ok := parseInteger(member)
+		if !ok {
+			return false
+		}
+		_, found := set.search(n)
+		return found
+	case setListpack:
+		return set.packed.index(member) >= 0
+	default:
+		return set.members[member]
+	}
+}
+
+// Add adds member, reporting false when it was already a member. The set
+// first converts to a less compact encoding when member does not fit in its
+// own, not being an integer or going over limits, so that adding many
+// members at once stays linear.
+func (set *ledisSet) Add(member string, limits encodingLimits) bool {
+	if set.encoding == setIntset {
+		n, ok := parseInteger(member)
+		if ok {
+			i, found := set.search(n)
+			if found {
+				return false
+			}
+			if set.size < limits.setMaxIntsetEntries {
+				set.ints = append(set.ints, 0)
+				copy(set.ints[i+1:], set.ints[i:])
+				set.ints[i] = n
+				set.size++
+				return true
+			}
+		}
+		if set.size < limits.setMaxListpackEntries && len(member) <= limits.setMaxListpackValue && limits.fitsListpack(set) {
+			set.convert(setListpack)
+		} else {
+			set.convert(setHashtable)
+		}
+	}
+
+	if set.Has(member) {
+		return false
+	}
+	if set.encoding == setListpack && (set.size >= limits.setMaxListpackEntries || len(member) > limits.setMaxListpackValue) {
+		set.convert(setHashtable)
+	}
+	if set.encoding == setListpack {
+		set.packed = set.packed.append(member)
+		set.size++
+	} else {
+		set.members[member] = true
+		set.bytes += memberSize(member)
+	}
+	return true
+}
+
+// Remove removes member, reporting false when it was not a member
+func (set *ledisSet) Remove(member string) bool {
+	switch set.encoding {
+	case setIntset:
+		n, ok := parseInteger(member)
+		if !ok {
+			return false
+		}
+		i, found := set.search(n)
+		if !found {
+			return false
+		}
+		set.ints = append(set.ints[:i], set.ints[i+1:]...)
+	case setListpack:
+		i := set.packed.index(member)
+		if i < 0 {
+			return false
+		}
+		start := set.packed.find(i)
+		_, end := set.packed.entry(start)
+		set.packed = set.packed.splice(start, end)
+	default:
+		if !set.members[member] {
+			return false
+		}
+		delete(set.members, member)
+		set.bytes -= memberSize(member)
+		return true
+	}
+	set.size--
+	return true
+}
+
+// Each calls fn for every member, until it returns false
+func (set *ledisSet) Each(fn func(member string) bool) {
+	if set == nil {
+		return
+	}
+	switch set.encoding {
+	case setIntset:
+		for _, n := range set.ints {
+			if !fn(strconv.FormatInt(n, 10)) {
+				return
+			}
+		}
+	case setListpack:
+		set.packed.each(fn)
+	default:
+		for member := range set.members {
+			if !fn(member) {
+				return
+			}
+		}
+	}
+}
+
+// Members returns the members, in no particular order
+func (set *ledisSet) Members() []string {
+	members := make([]string, 0, set.Len())
+	set.Each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// Random returns a member of a non empty set. Picking one in a map relies on
+// the random start of its iteration, like Redis' own sampling the
+// distribution is not perfectly uniform.
+func (set *ledisSet) Random() string {
+	switch set.encoding {
+	case setIntset:
+		return strconv.FormatInt(set.ints[rand.Intn(len(set.ints))], 10)
+	case setListpack:
+		val, _ := set.packed.entry(set.packed.find(rand.Intn(set.size)))
+		return val
+	default:
+		for member := range set.members {
+			return member
+		}
+		return ""
+	}
+}
+
+// convert changes the encoding of the set to a less compact one
+func (set *ledisSet) convert(encoding int) {
+	members := set.Members()
+	*set = ledisSet{encoding: encoding}
+	if encoding == setListpack {
+		for _, member := range members {
+			set.packed = set.packed.append(member)
+		}
+		set.size = len(members)
+		return
+	}
+	set.members = make(map[string]bool, len(members))
+	for _, member := range members {
+		set.members[member] = true
+		set.bytes += memberSize(member)
+	}
+}
+
+// clone returns a copy of the set in the same encoding
+func (set *ledisSet) clone() *ledisSet {
+	copied := *set
+	copied.ints = append([]int64(nil), set.ints...)
+	copied.packed = append(listpack(nil), set.packed...)
+	if set.members != nil {
+		copied.members = make(map[string]bool, len(set.members))
+		for member := range set.members {
+			copied.members[member] = true
+		}
+	}
+	return &copied
+}
+
+// memory returns the estimated bytes used by the members
+func (set *ledisSet) memory() int64 {
+	switch set.encoding {
+	case setIntset:
+		return int64(8 * len(set.ints))
+	case setListpack:
+		return int64(len(set.packed))
+	default:
+		return set.bytes
+	}
+}
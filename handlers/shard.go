@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultShards = 16
+
+// shard is a partition of the keys of a database, with its own lock so that
+// commands on keys of different shards run in parallel. Commands only
+// reading keys take the read lock of their shards.
+type shard struct {
+	lock       sync.RWMutex
+	Data       map[string]LedisData
+	ExpireTime map[string]int64
+	// index orders the keys by hash for SCAN
+	index *scanIndex
+	// used is the estimated memory used by the keys, see keyMeta
+	used int64
+	// keys counts the keys by type and expiring the keys with an
+	// expiration. They are changed with the write lock held and read
+	// atomically, so that the metrics do not lock the shards.
+	keys     [3]int64
+	expiring int64
+
+	// id orders the locks of all the shards of the store, see acquire
+	id int
+	db *ledisDB
+}
+
+func newShard(db *ledisDB, id int) *shard {
+	return &shard{
+		Data:       make(map[string]LedisData),
+		ExpireTime: make(map[string]int64),
+		index:      newScanIndex(),
+		id:         id,
+		db:         db,
+	}
+}
+
+// shardOf returns the shard holding key
+func (db *ledisDB) shardOf(key string) *shard {
+	return db.shards[keyHash(key)%uint64(len(db.shards))]
+}
+
+func (db *ledisDB) shardsOf(keys ...string) []*shard {
+	shards := make([]*shard, 0, len(keys))
+	for _, key := range keys {
+		shards = append(shards, db.shardOf(key))
+	}
+	return shards
+}
+
+// acquire locks shards, for writing or only for reading, once each and in
+// the order of their ids, so that commands locking many shards, even of
+// different databases, never deadlock. It returns the function releasing
+// them. In serial mode, where commands run one at a time, it locks nothing.
+func acquire(write bool, shards []*shard) func() {
+	if len(shards) == 0 || shards[0].db.serial {
+		return func() {}
+	}
+
+	sorted := make([]*shard, len(shards))
+	copy(sorted, shards)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].id < sorted[j].id })
+	locked := sorted[:0]
+	for _, s := range sorted {
+		if len(locked) > 0 && locked[len(locked)-1] == s {
+			continue
+		}
+		locked = append(locked, s)
+	}
+
+	start := time.Now()
+	for _, s := range locked {
+		if write {
+			s.lock.Lock()
+		} else {
+			s.lock.RLock()
+		}
+	}
+	kind := lockRead
+	if write {
+		kind = lockWrite
+	}
+	shards[0].db.metrics.lockWaited(kind, time.Since(start))
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			if write {
+				locked[i].lock.Unlock()
+			} else {
+				locked[i].lock.RUnlock()
+			}
+		}
+	}
+}
+
+// lockShards write locks shards like acquire. Releasing them then serves the
+// clients blocked on the lists pushed to meanwhile.
+func lockShards(shards []*shard) func() {
+	release := acquire(true, shards)
+	return func() {
+		release()
+		var served []*ledisDB
+	next:
+		for _, s := range shards {
+			for _, db := range served {
+				if db == s.db {
+					continue next
+				}
+			}
+			served = append(served, s.db)
+			s.db.serveReady()
+		}
+	}
+}
+
+// lockKeys write locks the shards holding keys, see lockShards
+func (db *ledisDB) lockKeys(keys ...string) func() {
+	return lockShards(db.shardsOf(keys...))
+}
+
+// rlockKeys read locks the shards holding keys
+func (db *ledisDB) rlockKeys(keys ...string) func() {
+	return acquire(false, db.shardsOf(keys...))
+}
+
+// lockAll write locks all the shards of dbs, for the commands working on
+// whole databases
+func lockAll(dbs ...*ledisDB) func() {
+	return lockShards(allShards(dbs))
+}
+
+// rlockAll read locks all the shards of dbs
+func rlockAll(dbs ...*ledisDB) func() {
+	return acquire(false, allShards(dbs))
+}
+
+func allShards(dbs []*ledisDB) []*shard {
+	shards := []*shard{}
+	for _, db := range dbs {
+		shards = append(shards, db.shards...)
+	}
+	return shards
+}
+
+// lookup returns the value at key, recording the access. The shard lock of
+// key must be held, as for all the accessors below.
+func (db *ledisDB) lookup(key string) (LedisData, bool) {
+	val, ok := db.shardOf(key).Data[key]
+	if ok {
+		val.meta.touch()
+	}
+	return val, ok
+}
+
+// peek returns the value at key without recording the access, for the
+// commands inspecting keys
+func (db *ledisDB) peek(key string) (LedisData, bool) {
+	val, ok := db.shardOf(key).Data[key]
+	return val, ok
+}
+
+// put stores val at key, keeping its expiration. A value coming from
+// another key keeps its metadata and encoding, a new one is encoded and
+// sized.
+func (db *ledisDB) put(key string, val LedisData) {
+	s := db.shardOf(key)
+	if old, ok := s.Data[key]; ok {
+		s.account(-keySize(key, old.meta))
+		atomic.AddInt64(&s.keys[old.DataType], -1)
+	} else {
+		s.index.insert(key)
+	}
+	atomic.AddInt64(&s.keys[val.DataType], 1)
+	if val.meta == nil {
+		val = val.encode(db.limits())
+		val.meta = newKeyMeta(valueSize(val))
+	}
+	s.Data[key] = val
+	s.account(keySize(key, val.meta))
+}
+
+// remove deletes key and its expiration
+func (db *ledisDB) remove(key string) {
+	s := db.shardOf(key)
+	if old, ok := s.Data[key]; ok {
+		s.account(-keySize(key, old.meta))
+		s.index.remove(key)
+		atomic.AddInt64(&s.keys[old.DataType], -1)
+	}
+	delete(s.Data, key)
+	s.persist(key)
+}
+
+// expireTime returns the unix time key expires at, and false when it does
+// not expire
+func (db *ledisDB) expireTime(key string) (int64, bool) {
+	expireTime, ok := db.shardOf(key).ExpireTime[key]
+	return expireTime, ok
+}
+
+func (db *ledisDB) setExpireTime(key string, expireTime int64) {
+	s := db.shardOf(key)
+	if _, ok := s.ExpireTime[key]; !ok {
+		atomic.AddInt64(&s.expiring, 1)
+	}
+	s.ExpireTime[key] = expireTime
+}
+
+// persist removes the expiration of key
+func (db *ledisDB) persist(key string) {
+	db.shardOf(key).persist(key)
+}
+
+func (s *shard) persist(key string) {
+	if _, ok := s.ExpireTime[key]; ok {
+		atomic.AddInt64(&s.expiring, -1)
+		delete(s.ExpireTime, key)
+	}
+}
+
+// each calls fn for every key of the database, all its shards must be
+// locked
+func (db *ledisDB) each(fn func(key string, val LedisData)) {
+	for _, s := range db.shards {
+		for key, val := range s.Data {
+			fn(key, val)
+		}
+	}
+}
+
+// size returns the number of keys, all the shards must be locked
+func (db *ledisDB) size() int {
+	n := 0
+	for _, s := range db.shards {
+		n += len(s.Data)
+	}
+	return n
+}
+
+// flush deletes all the keys, all the shards must be write locked
+func (db *ledisDB) flush() {
+	atomic.AddInt64(&db.stats.dirty, int64(db.size()))
+	for _, s := range db.shards {
+		s.Data = make(map[string]LedisData)
+		s.ExpireTime = make(map[string]int64)
+		s.index = newScanIndex()
+		s.account(-s.used)
+		for dataType := range s.keys {
+			atomic.StoreInt64(&s.keys[dataType], 0)
+		}
+		atomic.StoreInt64(&s.expiring, 0)
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaults of slowlog-log-slower-than, in microseconds, and slowlog-max-len
+const (
+	defaultSlowlogSlowerThan = 10000
+	defaultSlowlogMaxLen     = 128
+)
+
+// like Redis, the slow log keeps at most slowlogMaxArgs arguments of a
+// command, and slowlogMaxArgLen bytes of each
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that ran for longer than slowlog-log-slower-than
+type slowlogEntry struct {
+	id        int64
+	timestamp int64
+	// duration is in microseconds
+	duration int64
+	argv     []string
+	addr     string
+}
+
+// slowlog is the ring buffer of the slowest commands, the newest ones
+// replacing the oldest ones once it holds slowlog-max-len entries
+type slowlog struct {
+	lock    sync.Mutex
+	entries []slowlogEntry
+	// next is the position of the next entry, and the oldest one once the
+	// buffer is full
+	next   int
+	size   int
+	nextID int64
+}
+
+// logSlow logs cmd if it ran for longer than the threshold, a negative
+// threshold disabling the log
+func (store *LedisStore) logSlow(c *client, cmd *command, elapsed time.Duration) {
+	threshold := atomic.LoadInt64(&store.slowlogSlowerThan)
+	if threshold < 0 || elapsed.Microseconds() < threshold {
+		return
+	}
+	entry := slowlogEntry{
+		timestamp: time.Now().Unix(),
+		duration:  elapsed.Microseconds(),
+		argv:      slowlogArgv(cmd),
+		addr:      c.addr,
+	}
+	store.slowlog.add(entry, int(atomic.LoadInt32(&store.slowlogMaxLen)))
+}
+
+// slowlogArgv returns the name and arguments of cmd, truncated as Redis does
+func slowlogArgv(cmd *command) []string {
+	args := append([]string{cmd.Name}, cmd.Args...)
+	argv := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			argv = append(argv, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		argv = append(argv, arg)
+	}
+	return argv
+}
+
+// add logs entry, keeping the newest maxLen entries
+func (log *slowlog) add(entry slowlogEntry, maxLen int) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	entry.id = log.nextID
+	log.nextID++
+	log.resize(maxLen)
+	if maxLen == 0 {
+		return
+	}
+	log.entries[log.next] = entry
+	log.next = (log.next + 1) % maxLen
+	if log.size < maxLen {
+		log.size++
+	}
+}
+
+// resize changes the capacity of the ring buffer to maxLen, dropping the
+// oldest entries that no longer fit
+func (log *slowlog) resize(maxLen int) {
+	if len(log.entries) == maxLen {
+		return
+	}
+	newest := log.newest(maxLen)
+	log.entries = make([]slowlogEntry, maxLen)
+	log.size = len(newest)
+	for i := range newest {
+		log.entries[i] = newest[len(newest)-1-i]
+	}
+	log.next = 0
+	if maxLen > 0 {
+		log.next = log.size % maxLen
+	}
+}
+
+// newest returns up to count entries, from the newest one
+func (log *slowlog) newest(count int) []slowlogEntry {
+	if count > log.size {
+		count = log.size
+	}
+	entries := make([]slowlogEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		entries = append(entries, log.entries[(log.next-i+len(log.entries))%len(log.entries)])
+	}
+	return entries
+}
+
+// slowlogCommand parses SLOWLOG GET [count], SLOWLOG LEN and SLOWLOG RESET
+func slowlogCommand(db *ledisDB, cmd *command) reply {
+	if len(cmd.Args) < 1 {
+		return errorReply(fmt.Errorf("SLOWLOG expects at least 1 argument"))
+	}
+
+	switch subcommand := strings.ToUpper(cmd.Args[0]); subcommand {
+	case "GET":
+		if len(cmd.Args) > 2 {
+			return errorReply(fmt.Errorf("SLOWLOG GET expects at most 1 argument"))
+		}
+		count := 10
+		if len(cmd.Args) == 2 {
+			n, err := strconv.Atoi(cmd.Args[1])
+			if err != nil || n < -1 {
+				return errorReply(fmt.Errorf("count should be greater than or equal to -1"))
+			}
+			count = n
+		}
+		return db.SlowlogGet(count)
+	case "LEN", "RESET":
+		if len(cmd.Args) != 1 {
+			return errorReply(fmt.Errorf("SLOWLOG %s expects no argument", subcommand))
+		}
+		if subcommand == "LEN" {
+			return db.SlowlogLen()
+		}
+		return db.SlowlogReset()
+	default:
+		return errorReply(fmt.Errorf("unknown SLOWLOG subcommand: %s", cmd.Args[0]))
+	}
+}
+
+// SlowlogGet returns count entries of the slow log, all of them for -1, from
+// the newest one. Each entry is its id, unix time, duration in microseconds,
+// arguments, client address and client name, always empty.
+func (db *ledisDB) SlowlogGet(count int) reply {
+	log := db.slowlog
+	log.lock.Lock()
+	if count < 0 {
+		count = log.size
+	}
+	entries := log.newest(count)
+	log.lock.Unlock()
+
+	elems := make([]reply, 0, len(entries))
+	for _, entry := range entries {
+		elems = append(elems, arrayReply([]reply{
+			intReply(int(entry.id)),
+			intReply(int(entry.timestamp)),
+			intReply(int(entry.duration)),
+			bulkArrayReply(entry.argv),
+			bulkReply(entry.addr),
+			bulkReply(""),
+		}))
+	}
+	return arrayReply(elems)
+}
+
+func (db *ledisDB) SlowlogLen() reply {
+	db.slowlog.lock.Lock()
+	defer db.slowlog.lock.Unlock()
+	return intReply(db.slowlog.size)
+}
+
+// SlowlogReset empties the slow log, ids keep increasing
+func (db *ledisDB) SlowlogReset() reply {
+	log := db.slowlog
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	log.entries = make([]slowlogEntry, len(log.entries))
+	log.next, log.size = 0, 0
+	return statusReply("OK")
+}
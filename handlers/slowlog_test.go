@@ -0,0 +1,69 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSlowlog(t *testing.T) {
+	store := handlers.NewLedisStore()
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	members := ""
+	for i := 0; i < 40; i++ {
+		members += " m" + strconv.Itoa(i)
+	}
+
+	g.Expect(SendCommand(`CONFIG GET slowlog-*`)).To(Equal("slowlog-log-slower-than\r\n10000\r\nslowlog-max-len\r\n128\r\n"))
+	tests := []ValidateExactTest{
+		{`CONFIG SET slowlog-log-slower-than -1`, "OK", "Negative thresholds disable the log"},
+		{`SLOWLOG LEN`, "0", ""},
+		{`SLOWLOG GET`, "(empty list or set)", ""},
+		{`SLOWLOG`, "ERROR: SLOWLOG expects at least 1 argument", ""},
+		{`SLOWLOG GET -2`, "ERROR: count should be greater than or equal to -1", ""},
+		{`SLOWLOG LEN 1`, "ERROR: SLOWLOG LEN expects no argument", ""},
+		{`SLOWLOG FOO`, "ERROR: unknown SLOWLOG subcommand: FOO", ""},
+		{`CONFIG SET slowlog-max-len -1`, "ERROR: invalid argument '-1' for CONFIG SET 'slowlog-max-len': argument must be a non negative number", ""},
+		{`CONFIG SET slowlog-log-slower-than 0`, "OK", "Logs every command, this one included"},
+		{`SET key ` + strings.Repeat("v", 130), "OK", ""},
+		{`SADD set` + members, "40", ""},
+		{`SLOWLOG LEN`, "3", "Commands are logged once they ran"},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+
+	// entries are id, timestamp, duration, argv, client address and name,
+	// from the newest one
+	entries := SendRESP([]string{"SLOWLOG", "GET", "3"})
+	g.Expect(entries).To(MatchRegexp(`^\*3\r\n` +
+		`\*6\r\n:3\r\n:\d{10}\r\n:\d+\r\n\*2\r\n\$7\r\nSLOWLOG\r\n\$3\r\nLEN\r\n\$\d+\r\n127\.0\.0\.1:\d+\r\n\$0\r\n\r\n` +
+		`\*6\r\n:2\r\n:\d{10}\r\n:\d+\r\n\*32\r\n\$4\r\nSADD\r\n\$3\r\nset\r\n(\$\d\r\nm\d+\r\n){29}\$23\r\n\.\.\. \(11 more arguments\)\r\n\$\d+\r\n127\.0\.0\.1:\d+\r\n\$0\r\n\r\n` +
+		`\*6\r\n:1\r\n:\d{10}\r\n:\d+\r\n\*3\r\n\$3\r\nSET\r\n\$3\r\nkey\r\n\$146\r\nv{128}\.\.\. \(2 more bytes\)\r\n\$\d+\r\n127\.0\.0\.1:\d+\r\n\$0\r\n\r\n$`))
+
+	tests = []ValidateExactTest{
+		{`CONFIG SET slowlog-max-len 2`, "OK", ""},
+		{`GET key`, strings.Repeat("v", 130), ""},
+		{`SLOWLOG LEN`, "2", "The oldest entries are dropped"},
+		{`SLOWLOG RESET`, "OK", ""},
+		{`SLOWLOG LEN`, "1", "Only the reset"},
+		{`CONFIG SET slowlog-max-len 0`, "OK", ""},
+		{`SLOWLOG RESET`, "OK", ""},
+		{`SLOWLOG GET -1`, "(empty list or set)", ""},
+		{`CONFIG SET slowlog-max-len 5`, "OK", ""},
+	}
+	for _, test := range tests {
+		g.Expect(SendCommand(test.command)).To(Equal(test.expect), test.testName)
+	}
+	g.Expect(SendRESP([]string{"SLOWLOG", "GET"})).To(MatchRegexp(`^\*1\r\n\*6\r\n:13\r\n`), "Ids keep increasing")
+}
@@ -0,0 +1,106 @@
+package handlers
+
+// snapshotStore is the layout of snapshot files. It keeps the layout of the
+// original LedisStore whatever the in-memory representation of the values,
+// so that snapshots stay readable across versions.
+type snapshotStore struct {
+	// Data and ExpireTime hold database 0, so that snapshots made before
+	// there were many databases restore to it
+	Data       map[string]snapshotData
+	ExpireTime map[string]int64
+
+	// Databases holds the other non empty databases by index
+	Databases map[int]snapshotDB
+}
+
+type snapshotDB struct {
+	Data       map[string]snapshotData
+	ExpireTime map[string]int64
+}
+
+type snapshotData struct {
+	DataType   ledisType
+	SetData    *map[string]bool
+	ListData   *[]string
+	StringData *string
+}
+
+// snapshot converts all the databases to their snapshot layout. All their
+// shards must be locked.
+func (db *ledisDB) snapshot() *snapshotStore {
+	db0 := db.dbs[0].snapshotDB()
+	snap := &snapshotStore{
+		Data:       db0.Data,
+		ExpireTime: db0.ExpireTime,
+		Databases:  make(map[int]snapshotDB),
+	}
+	for _, other := range db.dbs[1:] {
+		if other.size() > 0 {
+			snap.Databases[other.index] = other.snapshotDB()
+		}
+	}
+	return snap
+}
+
+func (db *ledisDB) snapshotDB() snapshotDB {
+	snap := snapshotDB{
+		Data:       make(map[string]snapshotData, db.size()),
+		ExpireTime: make(map[string]int64),
+	}
+	for _, s := range db.shards {
+		for key, val := range s.ExpireTime {
+			snap.ExpireTime[key] = val
+		}
+	}
+	db.each(func(key string, val LedisData) {
+		data := snapshotData{
+			DataType:   val.DataType,
+			StringData: val.StringData,
+		}
+		if val.SetData != nil {
+			setData := make(map[string]bool, val.SetData.Len())
+			for _, member := range val.SetData.Members() {
+				setData[member] = true
+			}
+			data.SetData = &setData
+		}
+		if val.ListData != nil {
+			listData := val.ListData.Values()
+			data.ListData = &listData
+		}
+		snap.Data[key] = data
+	})
+	return snap
+}
+
+// restore loads the keys of snap, overwriting the existing ones. All the
+// shards must be write locked. Expirations of keys missing from the
+// snapshot are dropped.
+func (db *ledisDB) restore(snap snapshotDB) {
+	for key, data := range snap.Data {
+		db.put(key, data.restore())
+	}
+	for key, val := range snap.ExpireTime {
+		if _, ok := snap.Data[key]; ok {
+			db.setExpireTime(key, val)
+		}
+	}
+}
+
+func (data snapshotData) restore() LedisData {
+	val := LedisData{
+		DataType:   data.DataType,
+		StringData: data.StringData,
+	}
+	if data.ListData != nil {
+		val.ListData = newLedisList(*data.ListData)
+	}
+	if data.SetData != nil {
+		members := make([]string, 0, len(*data.SetData))
+		for member := range *data.SetData {
+			members = append(members, member)
+		}
+		val.SetData = newLedisSet(members)
+	}
+	return val
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDatabases      = 16
+	defaultExpireInterval = 500 * time.Millisecond
+)
+
+// LedisStore is a Ledis engine: its logical databases and the state they
+// share. Stores are independent of each other, Close stops the background
+// goroutines of a store.
+type LedisStore struct {
+	dbs []*ledisDB
+	// number of shards of each database
+	shards int
+
+	pubsub   *pubsubHub
+	monitors *monitorHub
+
+	// enabled keyspace event classes, see notify-keyspace-events, read
+	// atomically
+	notifyFlags int32
+
+	// used is the estimated memory used by the keys of all the databases,
+	// kept within maxMemory (0 for no limit) by evicting keys as
+	// maxMemoryPolicy says. All are read atomically.
+	used             int64
+	maxMemory        int64
+	maxMemoryPolicy  int32
+	maxMemorySamples int32
+
+	// limits of the compact encodings, read atomically, see encodingLimits
+	listMaxListpackSize   int32
+	setMaxIntsetEntries   int32
+	setMaxListpackEntries int32
+	setMaxListpackValue   int32
+
+	expireInterval time.Duration
+
+	// commands running for longer than slowlogSlowerThan microseconds,
+	// negative to log none, are kept in the slow log, up to slowlogMaxLen of
+	// them. Both are read atomically.
+	slowlog           *slowlog
+	slowlogSlowerThan int64
+	slowlogMaxLen     int32
+
+	stats   storeStats
+	metrics storeMetrics
+
+	// serial is set in executor mode, where jobs carries the commands to
+	// the executor and shards are not locked, see WithExecutor
+	serial bool
+	jobs   chan func()
+	// closedLock runs the commands one at a time once the executor is
+	// stopped
+	closedLock sync.Mutex
+
+	// ctx is done once the store is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StoreOption configures a store created by NewLedisStore
+type StoreOption func(store *LedisStore)
+
+// WithDatabases sets the number of logical databases, 16 by default
+func WithDatabases(n int) StoreOption {
+	return func(store *LedisStore) {
+		if n > 0 {
+			store.dbs = make([]*ledisDB, n)
+		}
+	}
+}
+
+// WithShards sets the number of shards the keys of each database are
+// partitioned in, 16 by default. Commands on keys of different shards run in
+// parallel.
+func WithShards(n int) StoreOption {
+	return func(store *LedisStore) {
+		if n > 0 {
+			store.shards = n
+		}
+	}
+}
+
+// WithExpireInterval sets how often expired keys are removed, 500ms by
+// default
+func WithExpireInterval(interval time.Duration) StoreOption {
+	return func(store *LedisStore) {
+		if interval > 0 {
+			store.expireInterval = interval
+		}
+	}
+}
+
+// NewLedisStore creates a store and starts its background goroutines
+func NewLedisStore(options ...StoreOption) *LedisStore {
+	store := &LedisStore{
+		dbs:            make([]*ledisDB, defaultDatabases),
+		shards:         defaultShards,
+		pubsub:         newPubsubHub(),
+		monitors:       newMonitorHub(),
+		expireInterval: defaultExpireInterval,
+		jobs:           make(chan func()),
+		stats:          newStoreStats(),
+		metrics:        newStoreMetrics(),
+		slowlog:        &slowlog{},
+
+		slowlogSlowerThan: defaultSlowlogSlowerThan,
+		slowlogMaxLen:     defaultSlowlogMaxLen,
+
+		maxMemorySamples: defaultSamples,
+
+		listMaxListpackSize:   defaultListMaxListpackSize,
+		setMaxIntsetEntries:   defaultSetMaxIntsetEntries,
+		setMaxListpackEntries: defaultSetMaxListpackEntries,
+		setMaxListpackValue:   defaultSetMaxListpackValue,
+	}
+	for _, option := range options {
+		option(store)
+	}
+	for i := range store.dbs {
+		store.dbs[i] = store.newDB(i)
+	}
+	store.ctx, store.cancel = context.WithCancel(context.Background())
+
+	store.wg.Add(1)
+	if store.serial {
+		go store.executor()
+	} else {
+		go store.expiredCleaner()
+	}
+	return store
+}
+
+// Close stops the background goroutines of the store and releases the
+// clients blocked on it. The data stays readable.
+func (store *LedisStore) Close() error {
+	store.cancel()
+	store.wg.Wait()
+	return nil
+}
+
+func (store *LedisStore) newDB(index int) *ledisDB {
+	db := &ledisDB{
+		shards:     make([]*shard, store.shards),
+		blocked:    make(map[string][]*listWaiter),
+		ready:      make(map[string]bool),
+		index:      index,
+		LedisStore: store,
+	}
+	for i := range db.shards {
+		db.shards[i] = newShard(db, index*store.shards+i)
+	}
+	return db
+}
+
+// expiredCleaner removes the expired keys and samples the stats until the
+// store is closed
+func (store *LedisStore) expiredCleaner() {
+	defer store.wg.Done()
+
+	ticker := time.NewTicker(store.expireInterval)
+	defer ticker.Stop()
+	sampler := time.NewTicker(opsSampleInterval)
+	defer sampler.Stop()
+	for {
+		select {
+		case <-store.ctx.Done():
+			return
+		case <-ticker.C:
+			store.removeExpired()
+		case <-sampler.C:
+			store.stats.sampleOps()
+		}
+	}
+}
+
+// removeExpired removes the keys whose expiration time has passed
+func (store *LedisStore) removeExpired() {
+	timeNow := time.Now().Unix()
+	for _, db := range store.dbs {
+		for _, s := range db.shards {
+			// one shard at a time, commands on the others go on
+			release := acquire(true, []*shard{s})
+			for key, val := range s.ExpireTime {
+				if val-timeNow <= 0 {
+					db.remove(key)
+					atomic.AddInt64(&store.stats.expiredKeys, 1)
+					db.notify(notifyExpired, "expired", key)
+				}
+			}
+			release()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zealotnt/ledis-go/handlers"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestIndependentStores(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	first := handlers.NewLedisStore()
+	defer first.Close()
+	firstServer := httptest.NewServer(handlers.NewLedisHandler(first))
+	defer firstServer.Close()
+
+	second := handlers.NewLedisStore(handlers.WithDatabases(1), handlers.WithExpireInterval(10*time.Millisecond))
+	defer second.Close()
+	secondServer := httptest.NewServer(handlers.NewLedisHandler(second))
+	defer secondServer.Close()
+
+	serverUrl = firstServer.URL
+	g.Expect(SendCommand(`SET key first`)).To(Equal("OK"))
+	g.Expect(SendCommand(`CONFIG GET databases`)).To(Equal("databases\r\n16\r\n"))
+	serverUrl = secondServer.URL
+	g.Expect(SendCommand(`GET key`)).To(Equal("key not found"), "Stores do not share keys")
+	g.Expect(SendCommand(`CONFIG GET databases`)).To(Equal("databases\r\n1\r\n"))
+	g.Expect(SendCommand(`SET key second`)).To(Equal("OK"))
+	g.Expect(SendCommand(`FLUSHALL`)).To(Equal("OK"))
+	serverUrl = firstServer.URL
+	g.Expect(SendCommand(`GET key`)).To(Equal("first"), "FLUSHALL only flushes its store")
+}
+
+func TestCloseStore(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithExpireInterval(10 * time.Millisecond))
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	g.Expect(SendCommand(`SET key 1`)).To(Equal("OK"))
+	g.Expect(SendCommand(`EXPIRE key 1`)).To(Equal("1"))
+	g.Eventually(func() string { return SendCommand(`GET key`) }, 3*time.Second, 50*time.Millisecond).
+		Should(Equal("key not found"), "Expired keys are removed while the store is open")
+
+	// closing releases the blocked clients and stops the expire cleaner
+	blocked := SendCommandAsync(`BLPOP queue 0`)
+	time.Sleep(100 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		store.Close()
+		close(done)
+	}()
+	g.Eventually(done).Should(BeClosed())
+	g.Eventually(blocked).Should(Receive(Equal("(nil)")))
+
+	g.Expect(SendCommand(`SET key 1`)).To(Equal("OK"))
+	g.Expect(SendCommand(`EXPIRE key 1`)).To(Equal("1"))
+	time.Sleep(1500 * time.Millisecond)
+	g.Expect(SendCommand(`GET key`)).To(Equal("1"), "The expired key is no longer removed")
+}
+
+func TestShardedLocking(t *testing.T) {
+	store := handlers.NewLedisStore(handlers.WithShards(4))
+	defer store.Close()
+	server := httptest.NewServer(handlers.NewLedisHandler(store))
+	defer server.Close()
+	serverUrl = server.URL
+	g := NewGomegaWithT(t)
+
+	g.Expect(SendCommand(`SADD left a b c d`)).To(Equal("4"))
+	g.Expect(SendCommand(`RPUSH ping 1 2 3 4`)).To(Equal("4"))
+
+	// commands locking the same keys in opposite orders run concurrently,
+	// and clients blocked on a list are served by pushes to another shard
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			member := string(rune('a' + i/2))
+			for j := 0; j < 20; j++ {
+				if i%2 == 0 {
+					SendCommand(`SMOVE left right ` + member)
+					SendCommand(`BLMOVE ping pong LEFT RIGHT 1`)
+				} else {
+					SendCommand(`SMOVE right left ` + member)
+					SendCommand(`BLMOVE pong ping LEFT RIGHT 1`)
+				}
+				SendCommand(`SUNIONSTORE union right left`)
+				SendCommand(`KEYS *`)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	g.Eventually(done, 30*time.Second).Should(BeClosed())
+
+	g.Expect(SendCommand(`SUNIONSTORE union right left`)).To(Equal("4"), "No member is lost")
+	db, _ := store.DB(0)
+	ping, _ := db.LLen("ping")
+	pong, _ := db.LLen("pong")
+	g.Expect(ping+pong).To(Equal(4), "No element is lost")
+}
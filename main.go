@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 
@@ -8,16 +9,36 @@ import (
 )
 
 func main() {
+	databases := flag.Int("databases", 16, "number of logical databases")
+	executor := flag.Bool("executor", false, "run the commands one at a time on a single goroutine instead of locking keys")
+	maxMemory := flag.String("maxmemory", "0", "memory limit, like 100mb, 0 for no limit")
+	maxMemoryPolicy := flag.String("maxmemory-policy", "noeviction", "keys evicted when the memory limit is reached")
+	flag.Parse()
+	if *databases < 1 {
+		log.Fatal("there should be at least 1 database")
+	}
+
 	log.Printf("Ledis server started\n")
 	addr := ":8080"
 
-	handlers.InitStore()
-
-	go handlers.ExpiredCleaner()
+	options := []handlers.StoreOption{handlers.WithDatabases(*databases)}
+	if *executor {
+		options = append(options, handlers.WithExecutor())
+	}
+	store := handlers.NewLedisStore(options...)
+	defer store.Close()
+	if err := store.ConfigSet("maxmemory", *maxMemory); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.ConfigSet("maxmemory-policy", *maxMemoryPolicy); err != nil {
+		log.Fatal(err)
+	}
 
 	mux := http.NewServeMux()
-	handler := &handlers.LedisHandler{}
-	mux.Handle("/", handler)
+	mux.Handle("/", handlers.NewLedisHandler(store))
+	mux.Handle("/subscribe", handlers.NewSubscribeHandler(store))
+	mux.Handle("/monitor", handlers.NewMonitorHandler(store))
+	mux.Handle("/metrics", handlers.NewMetricsHandler(store))
 	mux.Handle("/cli/", http.StripPrefix("/cli/", http.FileServer(http.Dir("./public"))))
 	log.Printf("Accepting connections at %s...\n", addr)
 	server := http.Server{Handler: mux, Addr: addr}